@@ -1,17 +1,64 @@
 package main
 
 import (
+	"errors"
+
 	"github.com/caarlos0/env/v8"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 
+	"github.com/muskelo/ns_server/httpadapter/internal/auth"
 	"github.com/muskelo/ns_server/httpadapter/internal/server"
 	pb "github.com/muskelo/ns_server/protos/storage"
 )
 
 type config struct {
 	StorageAddr string `env:"NS_HTTPADAPTER_STORAGE_ADDR" envDefault:"storage:5200"`
-	Listen     string `env:"NS_HTTPADAPTER_LISTEN" envDefault:"0.0.0.0:5300"`
+	Listen      string `env:"NS_HTTPADAPTER_LISTEN" envDefault:"0.0.0.0:5300"`
+
+	// false serves everyone without token
+	Auth bool `env:"NS_HTTPADAPTER_AUTH" envDefault:"true"`
+	// keys of JWT, at least one of them is required with auth
+	JWTSecretFile     string   `env:"NS_HTTPADAPTER_JWT_SECRET_FILE"`
+	JWTPublicKeyFiles []string `env:"NS_HTTPADAPTER_JWT_PUBLIC_KEY_FILES" envSeparator:","`
+	JWKSFile          string   `env:"NS_HTTPADAPTER_JWT_JWKS_FILE"`
+	// checked if set
+	JWTIssuer   string `env:"NS_HTTPADAPTER_JWT_ISSUER"`
+	JWTAudience string `env:"NS_HTTPADAPTER_JWT_AUDIENCE"`
+	// token is taken from cookie when GET or HEAD request has no Authorization
+	// header
+	JWTCookie string `env:"NS_HTTPADAPTER_JWT_COOKIE" envDefault:"ns_token"`
+}
+
+func newVerifier(cfg config) (*auth.Verifier, error) {
+	keys := make([]auth.Key, 0)
+	if cfg.JWTSecretFile != "" {
+		key, err := auth.LoadSecret(cfg.JWTSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	for _, file := range cfg.JWTPublicKeyFiles {
+		fileKeys, err := auth.LoadPublicKeys(file)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if cfg.JWKSFile != "" {
+		fileKeys, err := auth.LoadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no JWT keys, set NS_HTTPADAPTER_JWT_SECRET_FILE, NS_HTTPADAPTER_JWT_PUBLIC_KEY_FILES or NS_HTTPADAPTER_JWT_JWKS_FILE, or disable auth with NS_HTTPADAPTER_AUTH=false")
+	}
+	verifier := auth.NewVerifier(keys, cfg.JWTIssuer, cfg.JWTAudience)
+	verifier.Cookie = cfg.JWTCookie
+	return verifier, nil
 }
 
 func main() {
@@ -20,6 +67,15 @@ func main() {
 		panic(err)
 	}
 
+	var verifier *auth.Verifier
+	if cfg.Auth {
+		var err error
+		verifier, err = newVerifier(cfg)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	conn, err := grpc.Dial(cfg.StorageAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		panic(err)
@@ -27,7 +83,7 @@ func main() {
 	defer conn.Close()
 	client := pb.NewStorageServiceClient(conn)
 
-	err = server.Run(client, cfg.Listen)
+	err = server.Run(client, cfg.Listen, verifier)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,139 @@
+// Package auth verifies JWT bearer tokens of requests. Tokens are signed
+// with HS256, RS256 or EdDSA and must have "sub" and "exp" claims.
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// request has neither Authorization header nor token cookie
+	ErrNoToken = errors.New("missing token")
+	// no key of token algorithm and key id
+	ErrNoKey = errors.New("no key for token")
+)
+
+// clock skew allowed when checking "exp" and "nbf"
+const leeway = time.Minute
+
+// Key verifies tokens, ID is matched against "kid" header when both are set
+type Key struct {
+	ID string
+	// []byte for HS256, *rsa.PublicKey for RS256, ed25519.PublicKey for EdDSA
+	Key any
+}
+
+type Verifier struct {
+	keys   []Key
+	parser *jwt.Parser
+	// name of cookie with token, used without Authorization header by GET
+	// and HEAD requests only. Browsers send cookies with forms posted by
+	// other sites too.
+	Cookie string
+}
+
+// NewVerifier accepts tokens signed by keys, issuer and audience are checked
+// if not empty
+func NewVerifier(keys []Key, issuer, audience string) *Verifier {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	}
+	if issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+	return &Verifier{keys: keys, parser: jwt.NewParser(options...)}
+}
+
+// Identity is authenticated user
+type Identity struct {
+	Subject string
+	// JSON of all claims of token
+	Claims []byte
+}
+
+// Authenticate verifies token from "Authorization: Bearer" header or cookie
+// of safe request
+func (v *Verifier) Authenticate(r *http.Request) (*Identity, error) {
+	token := ""
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, credentials, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return nil, errors.New("authorization scheme must be Bearer")
+		}
+		token = strings.TrimSpace(credentials)
+	} else if v.Cookie != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if cookie, err := r.Cookie(v.Cookie); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return nil, ErrNoToken
+	}
+	return v.Verify(token)
+}
+
+// Verify checks signature and claims of token
+func (v *Verifier) Verify(token string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, err
+	}
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return nil, err
+	}
+	if subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Subject: subject, Claims: data}, nil
+}
+
+// keys that can verify token
+func (v *Verifier) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	set := jwt.VerificationKeySet{}
+	for _, key := range v.keys {
+		if kid != "" && key.ID != "" && key.ID != kid {
+			continue
+		}
+		// key of other type never verifies method, e.g. public key isn't
+		// used as HMAC secret
+		if algorithm(key.Key) == token.Method.Alg() {
+			set.Keys = append(set.Keys, key.Key)
+		}
+	}
+	if len(set.Keys) == 0 {
+		return nil, ErrNoKey
+	}
+	return set, nil
+}
+
+func algorithm(key any) string {
+	switch key.(type) {
+	case []byte:
+		return "HS256"
+	case *rsa.PublicKey:
+		return "RS256"
+	case ed25519.PublicKey:
+		return "EdDSA"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemFile := writeFile(t, "rsa.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	secretFile := writeFile(t, "secret", append(secret, '\n'))
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "OKP", "crv": "Ed25519", "kid": "ed", "x": base64.RawURLEncoding.EncodeToString(edPublic)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "EC", "kid": "ec"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := writeFile(t, "jwks.json", jwks)
+
+	key, err := LoadSecret(secretFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKeys, err := LoadPublicKeys(pemFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksKeys, err := LoadJWKS(jwksFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := append(append([]Key{key}, pemKeys...), jwksKeys...)
+	if len(keys) != 3 {
+		t.Fatalf("loaded %v keys, want 3", len(keys))
+	}
+	v := NewVerifier(keys, "issuer", "")
+
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := jwt.MapClaims{"sub": "alice", "iss": "issuer", "exp": exp, "role": "admin"}
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"HS256", sign(t, jwt.SigningMethodHS256, secret, "", valid), true},
+		{"RS256", sign(t, jwt.SigningMethodRS256, rsaKey, "", valid), true},
+		{"EdDSA", sign(t, jwt.SigningMethodEdDSA, edKey, "ed", valid), true},
+		{"other kid", sign(t, jwt.SigningMethodEdDSA, edKey, "other", valid), false},
+		{"other secret", sign(t, jwt.SigningMethodHS256, []byte(strings.Repeat("x", 32)), "", valid), false},
+		// public key used as HMAC secret
+		{"HS256 with public key", sign(t, jwt.SigningMethodHS256, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), "", valid), false},
+		{"HS384", sign(t, jwt.SigningMethodHS384, secret, "", valid), false},
+		{"expired", sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "alice", "iss": "issuer", "exp": time.Now().Add(-time.Hour).Unix()}), false},
+		{"no exp", sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "alice", "iss": "issuer"}), false},
+		{"no sub", sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"iss": "issuer", "exp": exp}), false},
+		{"other issuer", sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "alice", "iss": "other", "exp": exp}), false},
+		{"unsigned", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", valid), false},
+	}
+	for _, test := range tests {
+		identity, err := v.Verify(test.token)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%v: token is accepted", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		var claims map[string]any
+		if err := json.Unmarshal(identity.Claims, &claims); err != nil || identity.Subject != "alice" || claims["role"] != "admin" {
+			t.Errorf("%v: identity = %v %s, %v", test.name, identity.Subject, identity.Claims, err)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	v := NewVerifier([]Key{{Key: secret}}, "", "")
+	v.Cookie = "token"
+	token := sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := v.Authenticate(r); err != ErrNoToken {
+		t.Errorf("Authenticate without token err = %v, want ErrNoToken", err)
+	}
+	r.Header.Set("Authorization", "bearer "+token)
+	if identity, err := v.Authenticate(r); err != nil || identity.Subject != "bob" {
+		t.Errorf("Authenticate with header = %v, %v", identity, err)
+	}
+	r.Header.Set("Authorization", "Basic Ym9iOnB3")
+	if _, err := v.Authenticate(r); err == nil {
+		t.Error("Authenticate with Basic is accepted")
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", "token="+token)
+	if identity, err := v.Authenticate(r); err != nil || identity.Subject != "bob" {
+		t.Errorf("Authenticate with cookie = %v, %v", identity, err)
+	}
+	// form of other site can post with cookie of user
+	r = httptest.NewRequest("POST", "/remove/", strings.NewReader(`{"path":"/"}`))
+	r.Header.Set("Content-Type", "text/plain")
+	r.Header.Set("Cookie", "token="+token)
+	if _, err := v.Authenticate(r); err != ErrNoToken {
+		t.Errorf("Authenticate POST with cookie err = %v, want ErrNoToken", err)
+	}
+}
+
+func TestLoadJWKSRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadJWKS(writeFile(t, "jwks.json", jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, jwt.SigningMethodRS256, key, "", jwt.MapClaims{"sub": "carol", "exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := NewVerifier(keys, "", "").Verify(token); err != nil {
+		t.Error(err)
+	}
+}
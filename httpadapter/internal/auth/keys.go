@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// HS256 secret must be as long as hash
+const minSecretLen = 32
+
+// LoadSecret reads HS256 secret from file, trailing newline isn't part of it
+func LoadSecret(file string) (Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Key{}, err
+	}
+	secret := bytes.TrimRight(data, "\r\n")
+	if len(secret) < minSecretLen {
+		return Key{}, fmt.Errorf("%v: secret is shorter than 32 bytes", file)
+	}
+	return Key{Key: secret}, nil
+}
+
+// LoadPublicKeys reads PEM file with RSA or Ed25519 public keys or
+// certificates
+func LoadPublicKeys(file string) ([]Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key any
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			return nil, fmt.Errorf("%v: unexpected PEM block %v", file, block.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+		if algorithm(key) == "" {
+			return nil, fmt.Errorf("%v: key is neither RSA nor Ed25519", file)
+		}
+		keys = append(keys, Key{Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%v: no keys", file)
+	}
+	return keys, nil
+}
+
+// key of JSON Web Key Set, RFC 7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	// oct
+	K string `json:"k"`
+}
+
+// LoadJWKS reads JSON Web Key Set with RSA, Ed25519 and symmetric keys.
+// Keys of other types or for encryption are skipped.
+func LoadJWKS(file string) ([]Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%v: %w", file, err)
+	}
+	keys := make([]Key, 0)
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("%v: key %v: %w", file, i, err)
+		}
+		if key == nil || (k.Alg != "" && k.Alg != algorithm(key)) {
+			continue
+		}
+		keys = append(keys, Key{ID: k.Kid, Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%v: no keys", file)
+	}
+	return keys, nil
+}
+
+// key of supported type, nil for others
+func (k *jwk) key() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch {
+	case k.Kty == "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	case k.Kty == "oct":
+		secret, err := decode(k.K)
+		if err != nil {
+			return nil, err
+		}
+		if len(secret) < minSecretLen {
+			return nil, errors.New("secret is shorter than 32 bytes")
+		}
+		return secret, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// values of "archive" query parameter of download
+var archiveFormats = map[string]pb.ArchiveFormat{
+	"zip":     pb.ArchiveFormat_ZIP,
+	"tar":     pb.ArchiveFormat_TAR,
+	"tar.gz":  pb.ArchiveFormat_TAR_GZ,
+	"tgz":     pb.ArchiveFormat_TAR_GZ,
+	"tar.zst": pb.ArchiveFormat_TAR_ZST,
+	"tzst":    pb.ArchiveFormat_TAR_ZST,
+}
+
+var archiveContentTypes = map[pb.ArchiveFormat]string{
+	pb.ArchiveFormat_ZIP:     "application/zip",
+	pb.ArchiveFormat_TAR:     "application/x-tar",
+	pb.ArchiveFormat_TAR_GZ:  "application/gzip",
+	pb.ArchiveFormat_TAR_ZST: "application/zstd",
+}
+
+// downloadArchive streams directory as archive, size isn't known in advance
+// so response is chunked and has no ranges
+func downloadArchive(c *gin.Context, client pb.StorageServiceClient, path string, format pb.ArchiveFormat) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "path", path)
+	stream, err := client.Download(ctx, &pb.DownloadRequest{Archive: format})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	// errors like NotFound come with header
+	md, err := stream.Header()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if v := md.Get("name"); len(v) > 0 {
+		c.Header("Content-Disposition", contentDisposition("attachment", v[0]))
+	}
+	if v := md.Get("mod_time"); len(v) > 0 {
+		if modTime, err := strconv.ParseInt(v[0], 10, 64); err == nil {
+			c.Header("Last-Modified", httpDate(modTime))
+		}
+	}
+	c.Header("Content-Type", archiveContentTypes[format])
+
+	r := new(pb.StreamReader)
+	r.StorageService_DownloadClient(stream)
+	if _, err := io.Copy(c.Writer, r); err != nil {
+		c.Error(err)
+	}
+}
+
+// values of "conflict" of extract
+var extractConflicts = map[string]pb.ExtractConflict{
+	"fail":    pb.ExtractConflict_FAIL,
+	"skip":    pb.ExtractConflict_SKIP,
+	"replace": pb.ExtractConflict_REPLACE,
+}
+
+type extractJSON struct {
+	Path string `json:"path"`
+	Dir  string `json:"dir"`
+	// empty detects format by extension of path
+	Format   string `json:"format"`
+	Conflict string `json:"conflict"`
+}
+
+// Extract unpacks stored archive into directory. Failed extraction, also
+// "fail" on existing file, leaves entries extracted before the error.
+func Extract(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data := extractJSON{Conflict: "fail"}
+		if err := c.BindJSON(&data); err != nil {
+			c.Error(&HTTPError{400, "can't parse json"})
+			return
+		}
+		format := pb.ArchiveFormat_NONE
+		if data.Format != "" {
+			var ok bool
+			if format, ok = archiveFormats[data.Format]; !ok {
+				c.Error(&HTTPError{400, "unknown archive format"})
+				return
+			}
+		}
+		conflict, ok := extractConflicts[data.Conflict]
+		if !ok {
+			c.Error(&HTTPError{400, "unknown conflict"})
+			return
+		}
+
+		request := &pb.ExtractRequest{Path: data.Path, Dir: data.Dir, Format: format, Conflict: conflict}
+		response, err := client.Extract(c.Request.Context(), request)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(200, response)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/muskelo/ns_server/httpadapter/internal/auth"
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// Authenticate rejects requests without valid token with 401. Identity is
+// sent to storage as metadata of calls made with request context. CORS
+// preflight requests carry no token and pass.
+func Authenticate(verifier *auth.Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodOptions {
+			return
+		}
+		identity, err := verifier.Authenticate(c.Request)
+		if err != nil {
+			if errors.Is(err, auth.ErrNoToken) {
+				c.Header("WWW-Authenticate", "Bearer")
+			} else {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			}
+			c.Error(&HTTPError{401, err.Error()})
+			c.Abort()
+			return
+		}
+		ctx := metadata.AppendToOutgoingContext(c.Request.Context(),
+			pb.SubjectMD, identity.Subject, pb.ClaimsMD, string(identity.Claims))
+		c.Request = c.Request.WithContext(ctx)
+	}
+}
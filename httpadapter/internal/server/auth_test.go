@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/muskelo/ns_server/httpadapter/internal/auth"
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorHandler(), Authenticate(auth.NewVerifier([]auth.Key{{Key: secret}}, "", "")))
+	r.GET("/", func(c *gin.Context) {
+		md, _ := metadata.FromOutgoingContext(c.Request.Context())
+		c.String(200, "%v", md.Get(pb.SubjectMD))
+	})
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		authorization string
+		code          int
+		body          string
+	}{
+		{"", 401, ""},
+		{"Bearer invalid", 401, ""},
+		{"Bearer " + token, 200, "[alice]"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/", nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		r.ServeHTTP(w, request)
+		if w.Code != test.code || (test.body != "" && w.Body.String() != test.body) {
+			t.Errorf("%q: %v %v, want %v %v", test.authorization, w.Code, w.Body, test.code, test.body)
+		}
+		if w.Code == 401 && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%q: 401 without WWW-Authenticate", test.authorization)
+		}
+	}
+}
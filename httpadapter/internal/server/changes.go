@@ -0,0 +1,49 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// Changes lists changes since "cursor" like ListChanges. Response has
+// "changes" with "type" like "create", "path", "old_path", "dir" and
+// "time", next "cursor", "has_more" and "reset".
+func Changes(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+		if err != nil {
+			c.Error(&HTTPError{400, "invalid limit"})
+			return
+		}
+
+		request := &pb.ListChangesRequest{
+			Cursor: c.Query("cursor"),
+			Path:   c.DefaultQuery("path", "/"),
+			Limit:  int32(limit),
+		}
+		response, err := client.ListChanges(c.Request.Context(), request)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		changes := make([]gin.H, 0, len(response.Changes))
+		for _, change := range response.Changes {
+			changes = append(changes, gin.H{
+				"type":     eventType(change),
+				"path":     change.Path,
+				"old_path": change.OldPath,
+				"dir":      change.Dir,
+				"time":     change.Time,
+			})
+		}
+		c.JSON(200, gin.H{
+			"changes":  changes,
+			"cursor":   response.Cursor,
+			"has_more": response.HasMore,
+			"reset":    response.Reset_,
+		})
+	}
+}
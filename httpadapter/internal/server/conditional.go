@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// strong entity tag of file, from content hash if it is stored, otherwise
+// from revision (modification time and size)
+func etag(sha256, revision string) string {
+	if sha256 != "" {
+		return strconv.Quote(sha256)
+	}
+	return strconv.Quote(revision)
+}
+
+// HTTP date of time in unix milliseconds
+func httpDate(t int64) string {
+	return time.UnixMilli(t).UTC().Format(http.TimeFormat)
+}
+
+// match entity tag against If-Match or If-None-Match value, "*" matches any
+// existing file. Weak comparison ignores "W/" prefix.
+func matchETags(header, current string, weak bool) bool {
+	if current == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate conditional headers of GET against ETag and Last-Modified set in
+// response, returns 304, 412 or 0 if request should be served
+func checkPreconditions(c *gin.Context) int {
+	current := c.Writer.Header().Get("ETag")
+	modified, _ := http.ParseTime(c.Writer.Header().Get("Last-Modified"))
+
+	if h := c.GetHeader("If-Match"); h != "" {
+		if !matchETags(h, current, false) {
+			return 412
+		}
+	} else if t, err := http.ParseTime(c.GetHeader("If-Unmodified-Since")); err == nil && modified.After(t) {
+		return 412
+	}
+
+	if h := c.GetHeader("If-None-Match"); h != "" {
+		if matchETags(h, current, true) {
+			return 304
+		}
+	} else if t, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil && !modified.IsZero() && !modified.After(t) {
+		return 304
+	}
+	return 0
+}
+
+func respondPrecondition(c *gin.Context, code int) {
+	if code == 304 {
+		c.Status(304)
+		return
+	}
+	c.Error(&HTTPError{code, "precondition failed"})
+}
+
+// check If-Match and If-None-Match of write to path. Returned revision and
+// create make storage check them again atomically with write, so change
+// between check and write fails too.
+func writePreconditions(c *gin.Context, client pb.StorageServiceClient, path string) (revision string, create bool, err error) {
+	ifMatch, ifNoneMatch := c.GetHeader("If-Match"), c.GetHeader("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return "", false, nil
+	}
+	stat, err := client.Stat(c.Request.Context(), &pb.StatRequest{Path: path})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return "", false, err
+	}
+	current := ""
+	if err == nil {
+		current = etag(stat.Sha256, stat.Revision)
+	}
+
+	if ifMatch != "" {
+		if !matchETags(ifMatch, current, false) {
+			return "", false, &HTTPError{412, "precondition failed"}
+		}
+		revision = stat.Revision
+	}
+	if ifNoneMatch != "" {
+		if matchETags(ifNoneMatch, current, true) {
+			return "", false, &HTTPError{412, "precondition failed"}
+		}
+		if current == "" {
+			create = true
+		} else {
+			revision = stat.Revision
+		}
+	}
+	return revision, create, nil
+}
+
+// storage rejects conditional write when file is changed after check
+func preconditionError(err error) error {
+	switch status.Code(err) {
+	case codes.FailedPrecondition, codes.AlreadyExists:
+		return &HTTPError{412, "precondition failed"}
+	}
+	return err
+}
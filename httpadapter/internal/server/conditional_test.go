@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMatchETags(t *testing.T) {
+	tests := []struct {
+		header, current string
+		weak            bool
+		want            bool
+	}{
+		{`"a"`, `"a"`, false, true},
+		{`"b", "a"`, `"a"`, false, true},
+		{`"b"`, `"a"`, false, false},
+		{`W/"a"`, `"a"`, false, false},
+		{`W/"a"`, `"a"`, true, true},
+		{"*", `"a"`, false, true},
+		{"*", "", false, false},
+	}
+	for _, test := range tests {
+		if got := matchETags(test.header, test.current, test.weak); got != test.want {
+			t.Errorf("matchETags(%q, %q, %v) = %v, want %v", test.header, test.current, test.weak, got, test.want)
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	const modified = "Tue, 14 Nov 2023 22:13:20 GMT"
+	tests := []struct {
+		header, value string
+		want          int
+	}{
+		{"", "", 0},
+		{"If-None-Match", `"a"`, 304},
+		{"If-None-Match", `W/"a"`, 304},
+		{"If-None-Match", `"b"`, 0},
+		{"If-Modified-Since", modified, 304},
+		{"If-Modified-Since", "Tue, 14 Nov 2023 22:13:19 GMT", 0},
+		{"If-Match", `"a"`, 0},
+		{"If-Match", `"b"`, 412},
+		{"If-Unmodified-Since", "Tue, 14 Nov 2023 22:13:19 GMT", 412},
+		{"If-Unmodified-Since", modified, 0},
+	}
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/download/", nil)
+		if test.header != "" {
+			c.Request.Header.Set(test.header, test.value)
+		}
+		c.Header("ETag", `"a"`)
+		c.Header("Last-Modified", modified)
+		if got := checkPreconditions(c); got != test.want {
+			t.Errorf("checkPreconditions(%v: %v) = %v, want %v", test.header, test.value, got, test.want)
+		}
+	}
+}
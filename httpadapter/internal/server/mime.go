@@ -0,0 +1,38 @@
+package server
+
+import (
+	"mime"
+	"strings"
+)
+
+// types shown inline only when asked, others could run scripts in origin of
+// adapter, like HTML and SVG
+var inlineTypes = map[string]bool{
+	"text/plain":       true,
+	"text/csv":         true,
+	"application/pdf":  true,
+	"application/json": true,
+	"image/jpeg":       true,
+	"image/png":        true,
+	"image/gif":        true,
+	"image/webp":       true,
+	"image/bmp":        true,
+}
+
+// contentDisposition formats Content-Disposition header with quoted file name,
+// non-ASCII name is sent as RFC 2231 filename*
+func contentDisposition(disposition, name string) string {
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": name}); value != "" {
+		return value
+	}
+	return disposition
+}
+
+// inlineSafe reports if file of MIME type can be shown by browser inline
+func inlineSafe(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	return inlineTypes[mediaType] || strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/")
+}
@@ -0,0 +1,41 @@
+package server
+
+import "testing"
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", "attachment; filename=report.pdf"},
+		{"my report.pdf", `attachment; filename="my report.pdf"`},
+		{`a;b"c.txt`, `attachment; filename="a;b\"c.txt"`},
+		{"отчёт.txt", "attachment; filename*=utf-8''%D0%BE%D1%82%D1%87%D1%91%D1%82.txt"},
+	}
+	for _, test := range tests {
+		if got := contentDisposition("attachment", test.name); got != test.want {
+			t.Errorf("contentDisposition(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInlineSafe(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		want     bool
+	}{
+		{"text/plain; charset=utf-8", true},
+		{"image/png", true},
+		{"video/mp4", true},
+		{"application/pdf", true},
+		{"text/html; charset=utf-8", false},
+		{"image/svg+xml", false},
+		{"application/octet-stream", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := inlineSafe(test.mimeType); got != test.want {
+			t.Errorf("inlineSafe(%q) = %v, want %v", test.mimeType, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+var (
+	errInvalidRange  = errors.New("invalid range")
+	errUnsatisfiable = errors.New("range not satisfiable")
+)
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// serve Range request with 206, 416 or error, false if whole file must be
+// sent instead: range is malformed, covers more than file or If-Range doesn't
+// match
+func downloadRanges(c *gin.Context, client pb.StorageServiceClient, path string) bool {
+	stat, err := client.Stat(c.Request.Context(), &pb.StatRequest{Path: path})
+	if err != nil {
+		c.Error(err)
+		return true
+	}
+	c.Header("ETag", etag(stat.Sha256, stat.Revision))
+	c.Header("Last-Modified", httpDate(stat.ModTime))
+	if code := checkPreconditions(c); code != 0 {
+		respondPrecondition(c, code)
+		return true
+	}
+	if stat.Dir || !ifRangeMatch(c.GetHeader("If-Range"), stat) {
+		return false
+	}
+	ranges, err := parseRange(c.GetHeader("Range"), stat.Size)
+	if errors.Is(err, errUnsatisfiable) {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", stat.Size))
+		c.Error(&HTTPError{416, err.Error()})
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	// overlapping ranges are cheaper to send as whole file
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > stat.Size {
+		return false
+	}
+
+	download := func(r byteRange) (pb.StorageService_DownloadClient, error) {
+		ctx := metadata.AppendToOutgoingContext(c.Request.Context(), "path", path)
+		return client.Download(ctx, &pb.DownloadRequest{Offset: r.start, Length: r.length, Revision: stat.Revision})
+	}
+	write := func(w io.Writer, stream pb.StorageService_DownloadClient) error {
+		defer stream.CloseSend()
+		r := new(pb.StreamReader)
+		r.StorageService_DownloadClient(stream)
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	stream, err := download(ranges[0])
+	if err != nil {
+		c.Error(err)
+		return true
+	}
+	if err := setHeadersFromStream(c, stream); err != nil {
+		c.Error(err)
+		return true
+	}
+
+	// detection of type is best effort
+	mimeType := stat.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	if len(ranges) == 1 {
+		c.Header("Content-Range", ranges[0].contentRange(stat.Size))
+		c.Header("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		c.Header("Content-Type", mimeType)
+		c.Status(http.StatusPartialContent)
+		if err := write(c.Writer, stream); err != nil {
+			c.Error(err)
+		}
+		return true
+	}
+
+	mw := multipart.NewWriter(c.Writer)
+	c.Header("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	c.Status(http.StatusPartialContent)
+	for i, r := range ranges {
+		if i > 0 {
+			if stream, err = download(r); err != nil {
+				c.Error(err)
+				return true
+			}
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {mimeType},
+			"Content-Range": {r.contentRange(stat.Size)},
+		})
+		if err == nil {
+			err = write(part, stream)
+		}
+		if err != nil {
+			c.Error(err)
+			return true
+		}
+	}
+	if err := mw.Close(); err != nil {
+		c.Error(err)
+	}
+	return true
+}
+
+// If-Range matches when it is absent, equals strong ETag or equals
+// Last-Modified date
+func ifRangeMatch(ifRange string, stat *pb.StatResponse) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return ifRange == etag(stat.Sha256, stat.Revision)
+	}
+	return ifRange == httpDate(stat.ModTime)
+}
+
+// parse "bytes=0-99,200-,-50" into ranges clipped to size. Unsatisfiable
+// ranges are dropped, errUnsatisfiable is returned if none is left.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, errInvalidRange
+	}
+	ranges := make([]byteRange, 0)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				e, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || e < start {
+					return nil, errInvalidRange
+				}
+				if e < end {
+					end = e
+				}
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		if r.length > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	return ranges, nil
+}
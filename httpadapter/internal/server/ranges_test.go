@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		err    error
+	}{
+		{"bytes=0-9", "[{0 10}]", nil},
+		{"bytes=5-", "[{5 5}]", nil},
+		{"bytes=-3", "[{7 3}]", nil},
+		{"bytes=-30", "[{0 10}]", nil},
+		{"bytes=8-20", "[{8 2}]", nil},
+		{"bytes=0-1, 4-5,-1", "[{0 2} {4 2} {9 1}]", nil},
+		{"bytes=20-30,2-2", "[{2 1}]", nil},
+		{"bytes=10-", "", errUnsatisfiable},
+		{"bytes=-0", "", errUnsatisfiable},
+		{"bytes=5-1", "", errInvalidRange},
+		{"bytes=a-1", "", errInvalidRange},
+		{"bytes=1", "", errInvalidRange},
+		{"items=0-1", "", errInvalidRange},
+	}
+	for _, test := range tests {
+		ranges, err := parseRange(test.header, 10)
+		if !errors.Is(err, test.err) || err == nil && fmt.Sprint(ranges) != test.want {
+			t.Errorf("parseRange(%q) = %v, %v, want %v, %v", test.header, ranges, err, test.want, test.err)
+		}
+	}
+}
+
+func TestIfRangeMatch(t *testing.T) {
+	stat := &pb.StatResponse{Sha256: "abc", Revision: "rev", ModTime: 1700000000000}
+	tests := []struct {
+		ifRange string
+		want    bool
+	}{
+		{"", true},
+		{`"abc"`, true},
+		{`W/"abc"`, false},
+		{`"def"`, false},
+		{"Tue, 14 Nov 2023 22:13:20 GMT", true},
+		{"Tue, 14 Nov 2023 22:13:21 GMT", false},
+	}
+	for _, test := range tests {
+		if got := ifRangeMatch(test.ifRange, stat); got != test.want {
+			t.Errorf("ifRangeMatch(%q) = %v, want %v", test.ifRange, got, test.want)
+		}
+	}
+}
+
+// fakeStorage serves Stat and Download of one file
+type fakeStorage struct {
+	pb.StorageServiceClient
+	stat *pb.StatResponse
+	data []byte
+}
+
+func (f *fakeStorage) Stat(ctx context.Context, in *pb.StatRequest, opts ...grpc.CallOption) (*pb.StatResponse, error) {
+	return f.stat, nil
+}
+
+func (f *fakeStorage) Download(ctx context.Context, in *pb.DownloadRequest, opts ...grpc.CallOption) (pb.StorageService_DownloadClient, error) {
+	data := f.data[in.Offset:]
+	if in.Length > 0 {
+		data = data[:in.Length]
+	}
+	header := metadata.Pairs(
+		"name", f.stat.Name,
+		"mime_type", f.stat.MimeType,
+		"revision", f.stat.Revision,
+	)
+	return &fakeDownload{header: header, data: data}, nil
+}
+
+type fakeDownload struct {
+	grpc.ClientStream
+	header metadata.MD
+	data   []byte
+}
+
+func (d *fakeDownload) Header() (metadata.MD, error) { return d.header, nil }
+func (d *fakeDownload) CloseSend() error             { return nil }
+
+func (d *fakeDownload) Recv() (*pb.DownloadResponse, error) {
+	if d.data == nil {
+		return nil, io.EOF
+	}
+	response := &pb.DownloadResponse{Chunk: d.data}
+	d.data = nil
+	return response, nil
+}
+
+func TestDownloadRanges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := &fakeStorage{
+		stat: &pb.StatResponse{Name: "data", Size: 10, Revision: "rev"},
+		data: []byte("0123456789"),
+	}
+	r := gin.New()
+	r.Use(ErrorHandler())
+	r.GET("/download/", Download(client))
+
+	tests := []struct {
+		mimeType, contentType string
+	}{
+		{"text/plain", "text/plain"},
+		// type wasn't detected
+		{"", "application/octet-stream"},
+	}
+	for _, test := range tests {
+		client.stat.MimeType = test.mimeType
+		w := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/download/?path=/data", nil)
+		request.Header.Set("Range", "bytes=2-4")
+		r.ServeHTTP(w, request)
+		if w.Code != 206 || w.Body.String() != "234" {
+			t.Errorf("%q: %v %q, want 206 %q", test.mimeType, w.Code, w.Body, "234")
+		}
+		if got := w.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("%q: Content-Type = %q, want %q", test.mimeType, got, test.contentType)
+		}
+	}
+}
@@ -2,26 +2,53 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
+	"github.com/muskelo/ns_server/httpadapter/internal/auth"
 	pb "github.com/muskelo/ns_server/protos/storage"
 )
 
-func Run(client pb.StorageServiceClient, addr string) error {
+// Run serves API, nil verifier disables authentication
+func Run(client pb.StorageServiceClient, addr string, verifier *auth.Verifier) error {
 	r := gin.Default()
 	r.Use(ErrorHandler())
+	if verifier != nil {
+		r.Use(Authenticate(verifier))
+	}
 	r.Handle("POST", "/mkdir/", Mkdir(client))
 	r.Handle("POST", "/readdir/", ReadDir(client))
 	r.Handle("POST", "/remove/", Remove(client))
+	r.Handle("POST", "/removeall/", RemoveAll(client))
+	r.Handle("POST", "/move/", Move(client))
+	r.Handle("POST", "/copy/", Copy(client))
+	r.Handle("POST", "/extract/", Extract(client))
 	r.Handle("POST", "/upload/", Upload(client))
 	r.Handle("GET", "/download/", Download(client))
+	r.Handle("GET", "/stat/", Stat(client))
+	r.Handle("GET", "/thumbnail/", Thumbnail(client))
+	r.Handle("GET", "/walk/", Walk(client))
+	r.Handle("GET", "/search/", Search(client))
+	r.Handle("GET", "/searchcontent/", SearchContent(client))
+	r.Handle("GET", "/watch/", Watch(client))
+	r.Handle("GET", "/changes/", Changes(client))
+
+	tus := r.Group("/tus", TusResumable())
+	tus.Handle("OPTIONS", "/", TusOptions())
+	tus.Handle("POST", "/", TusCreate(client))
+	tus.Handle("HEAD", "/:id", TusHead(client))
+	tus.Handle("PATCH", "/:id", TusPatch(client))
+	tus.Handle("DELETE", "/:id", TusDelete(client))
 	return r.Run(addr)
 }
 
@@ -34,7 +61,7 @@ func Mkdir(client pb.StorageServiceClient) gin.HandlerFunc {
 			return
 		}
 
-		ctx := context.TODO()
+		ctx := c.Request.Context()
 		_, err = client.Mkdir(ctx, request)
 		if err != nil {
 			c.Error(err)
@@ -56,7 +83,7 @@ func ReadDir(client pb.StorageServiceClient) gin.HandlerFunc {
 			return
 		}
 
-		response, err := client.ReadDir(context.Background(), request)
+		response, err := client.ReadDir(c.Request.Context(), request)
 		if err != nil {
 			c.Error(err)
 			return
@@ -79,12 +106,257 @@ func Remove(client pb.StorageServiceClient) gin.HandlerFunc {
 			return
 		}
 
-		ctx := context.TODO()
-		_, err = client.Remove(ctx, &pb.RemoveRequest{Path: data.Path})
+		revision, _, err := writePreconditions(c, client, data.Path)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		ctx := c.Request.Context()
+		_, err = client.Remove(ctx, &pb.RemoveRequest{Path: data.Path, Revision: revision})
+		if err != nil {
+			c.Error(err)
+			return
+		}
+	}
+}
+
+func RemoveAll(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data := removeJSON{}
+		err := c.BindJSON(&data)
+		if err != nil {
+			c.Error(&HTTPError{400, "can't parse json"})
+			return
+		}
+
+		// request context, so closed connection stops removal
+		response, err := client.RemoveAll(c.Request.Context(), &pb.RemoveAllRequest{Path: data.Path})
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(200, response)
+	}
+}
+
+type moveJSON struct {
+	Src      string `json:"src"`
+	Dst      string `json:"dst"`
+	Mode     string `json:"mode"`
+	Revision string `json:"revision"`
+}
+
+func Move(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data := moveJSON{Mode: "create"}
+		err := c.BindJSON(&data)
+		if err != nil {
+			c.Error(&HTTPError{400, "can't parse json"})
+			return
+		}
+		mode, ok := writeModes[data.Mode]
+		if !ok {
+			c.Error(&HTTPError{400, "unknown mode"})
+			return
+		}
+
+		request := &pb.MoveRequest{Src: data.Src, Dst: data.Dst, Mode: mode, Revision: data.Revision}
+		_, err = client.Move(c.Request.Context(), request)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+	}
+}
+
+// Copy waits until copy is complete and returns its last progress
+func Copy(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data := moveJSON{Mode: "create"}
+		err := c.BindJSON(&data)
+		if err != nil {
+			c.Error(&HTTPError{400, "can't parse json"})
+			return
+		}
+		mode, ok := writeModes[data.Mode]
+		if !ok {
+			c.Error(&HTTPError{400, "unknown mode"})
+			return
+		}
+
+		request := &pb.CopyRequest{Src: data.Src, Dst: data.Dst, Mode: mode, Revision: data.Revision}
+		stream, err := client.Copy(c.Request.Context(), request)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		response := &pb.CopyResponse{}
+		for !response.Done {
+			response, err = stream.Recv()
+			if err != nil {
+				c.Error(err)
+				return
+			}
+		}
+		c.JSON(200, response)
+	}
+}
+
+func Stat(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Query("path")
+		if path == "" {
+			c.Error(&HTTPError{400, "path missing"})
+			return
+		}
+
+		response, err := client.Stat(c.Request.Context(), &pb.StatRequest{Path: path})
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(200, response)
+	}
+}
+
+// Walk streams entries as NDJSON
+func Walk(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Query("path")
+		if path == "" {
+			c.Error(&HTTPError{400, "path missing"})
+			return
+		}
+		maxDepth, err := strconv.Atoi(c.DefaultQuery("max_depth", "0"))
+		if err != nil {
+			c.Error(&HTTPError{400, "invalid max_depth"})
+			return
+		}
+
+		request := &pb.WalkRequest{
+			Path:     path,
+			MaxDepth: int32(maxDepth),
+			Include:  c.QueryArray("include"),
+			Exclude:  c.QueryArray("exclude"),
+			Cursor:   c.Query("cursor"),
+		}
+		stream, err := client.Walk(c.Request.Context(), request)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		writeNDJSON(c, stream)
+	}
+}
+
+// Search streams results as NDJSON like Walk
+func Search(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Query("path")
+		if path == "" {
+			c.Error(&HTTPError{400, "path missing"})
+			return
+		}
+		request := &pb.SearchRequest{
+			Path:   path,
+			Glob:   c.Query("glob"),
+			Regexp: c.Query("regexp"),
+		}
+		switch c.DefaultQuery("type", "any") {
+		case "any":
+		case "file":
+			request.Type = pb.SearchRequest_FILE
+		case "dir":
+			request.Type = pb.SearchRequest_DIR
+		default:
+			c.Error(&HTTPError{400, "unknown type"})
+			return
+		}
+		// numeric parameters, missing is 0
+		for name, value := range map[string]*int64{
+			"min_size":        &request.MinSize,
+			"max_size":        &request.MaxSize,
+			"modified_after":  &request.ModifiedAfter,
+			"modified_before": &request.ModifiedBefore,
+		} {
+			v, err := strconv.ParseInt(c.DefaultQuery(name, "0"), 10, 64)
+			if err != nil {
+				c.Error(&HTTPError{400, "invalid " + name})
+				return
+			}
+			*value = v
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+		if err != nil {
+			c.Error(&HTTPError{400, "invalid limit"})
+			return
+		}
+		request.Limit = int32(limit)
+
+		stream, err := client.Search(c.Request.Context(), request)
 		if err != nil {
 			c.Error(err)
 			return
 		}
+		writeNDJSON(c, stream)
+	}
+}
+
+func SearchContent(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Query("q")
+		if query == "" {
+			c.Error(&HTTPError{400, "q missing"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+		if err != nil {
+			c.Error(&HTTPError{400, "invalid limit"})
+			return
+		}
+
+		request := &pb.SearchContentRequest{
+			Query: query,
+			Path:  c.DefaultQuery("path", "/"),
+			Limit: int32(limit),
+		}
+		response, err := client.SearchContent(c.Request.Context(), request)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(200, response)
+	}
+}
+
+// stream of Walk or Search
+type entryStream interface {
+	Recv() (*pb.WalkResponse, error)
+}
+
+// write stream of entries as NDJSON, one object per line. Error after first
+// entry is sent as last line {"error": "..."}.
+func writeNDJSON(c *gin.Context, stream entryStream) {
+	// errors of request are returned with first message
+	response, err := stream.Recv()
+	if err != nil && !errors.Is(err, io.EOF) {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(200)
+	encoder := json.NewEncoder(c.Writer)
+	for err == nil {
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+		c.Writer.Flush()
+		response, err = stream.Recv()
+	}
+	if !errors.Is(err, io.EOF) {
+		encoder.Encode(gin.H{"error": status.Convert(err).Message()})
 	}
 }
 
@@ -94,19 +366,74 @@ func setHeadersFromStream(c *gin.Context, stream pb.StorageService_DownloadClien
 		return err
 	}
 
-	v := md.Get("name")
+	mimeType := "application/octet-stream"
+	v := md.Get("mime_type")
+	if len(v) > 0 && v[0] != "" {
+		mimeType = v[0]
+	}
+	c.Header("Content-Type", mimeType)
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	// "inline" query parameter asks to show file in browser
+	disposition := "attachment"
+	if inline, _ := strconv.ParseBool(c.Query("inline")); inline && inlineSafe(mimeType) {
+		disposition = "inline"
+	}
+	v = md.Get("name")
 	if len(v) > 0 {
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%v", v[0]))
+		c.Header("Content-Disposition", contentDisposition(disposition, v[0]))
 	} else {
-		c.Header("Content-Disposition", "attachment; filename=unknow")
+		c.Header("Content-Disposition", contentDisposition(disposition, "unknow"))
 	}
 
 	v = md.Get("size")
 	if len(v) > 0 {
 		c.Header("Accept-Length", v[0])
 	}
+
+	v = md.Get("revision")
+	if len(v) > 0 {
+		c.Header("X-Revision", v[0])
+	}
+
+	v = md.Get("mod_time")
+	if len(v) > 0 {
+		if modTime, err := strconv.ParseInt(v[0], 10, 64); err == nil {
+			c.Header("Last-Modified", httpDate(modTime))
+		}
+	}
+
+	var sha256 string
+	v = md.Get("sha256")
+	if len(v) > 0 {
+		if sum, err := hex.DecodeString(v[0]); err == nil {
+			c.Header("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+			sha256 = v[0]
+		}
+	}
+	if revision := md.Get("revision"); len(revision) > 0 {
+		c.Header("ETag", etag(sha256, revision[0]))
+	}
+	c.Header("Accept-Ranges", "bytes")
 	return nil
 }
+
+// hex SHA-256 from "Digest: sha-256=<base64>" header, empty if header has no
+// SHA-256
+func parseDigest(header string) (string, error) {
+	for _, part := range strings.Split(header, ",") {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || !strings.EqualFold(algorithm, "sha-256") {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(sum), nil
+	}
+	return "", nil
+}
 func Download(client pb.StorageServiceClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		path := c.Query("path")
@@ -114,8 +441,22 @@ func Download(client pb.StorageServiceClient) gin.HandlerFunc {
 			c.Error(&HTTPError{400, "can't parse json"})
 			return
 		}
+		if archive := c.Query("archive"); archive != "" {
+			format, ok := archiveFormats[archive]
+			if !ok {
+				c.Error(&HTTPError{400, "unknown archive format"})
+				return
+			}
+			downloadArchive(c, client, path, format)
+			return
+		}
+		if c.GetHeader("Range") != "" && downloadRanges(c, client, path) {
+			return
+		}
 
-		ctx := metadata.AppendToOutgoingContext(context.TODO(), "path", path)
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		ctx = metadata.AppendToOutgoingContext(ctx, "path", path)
 		stream, err := client.Download(ctx, &pb.DownloadRequest{})
 		if err != nil {
 			c.Error(err)
@@ -127,6 +468,10 @@ func Download(client pb.StorageServiceClient) gin.HandlerFunc {
 			c.Error(err)
 			return
 		}
+		if code := checkPreconditions(c); code != 0 {
+			respondPrecondition(c, code)
+			return
+		}
 
 		r := new(pb.StreamReader)
 		r.StorageService_DownloadClient(stream)
@@ -138,6 +483,13 @@ func Download(client pb.StorageServiceClient) gin.HandlerFunc {
 	}
 }
 
+// values of "mode" query parameter of upload
+var writeModes = map[string]pb.WriteMode{
+	"create":                 pb.WriteMode_CREATE,
+	"overwrite":              pb.WriteMode_OVERWRITE,
+	"overwrite-if-unchanged": pb.WriteMode_OVERWRITE_IF_UNCHANGED,
+}
+
 func Upload(client pb.StorageServiceClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		path := c.Query("path")
@@ -157,30 +509,67 @@ func Upload(client pb.StorageServiceClient) gin.HandlerFunc {
 			return
 		}
 
-		ctx := metadata.AppendToOutgoingContext(context.TODO(), "path", path)
+		mode, ok := writeModes[c.DefaultQuery("mode", "create")]
+		if !ok {
+			c.Error(&HTTPError{400, "unknown mode"})
+			return
+		}
+		revision := c.Query("revision")
+		if mode == pb.WriteMode_OVERWRITE_IF_UNCHANGED && revision == "" {
+			c.Error(&HTTPError{400, "revision missing"})
+			return
+		}
+		// If-Match and If-None-Match override mode
+		conditionRevision, create, err := writePreconditions(c, client, path)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		conditional := conditionRevision != "" || create
+		if create {
+			mode, revision = pb.WriteMode_CREATE, ""
+		} else if conditionRevision != "" {
+			mode, revision = pb.WriteMode_OVERWRITE_IF_UNCHANGED, conditionRevision
+		}
+
+		digest, err := parseDigest(c.GetHeader("Digest"))
+		if err != nil {
+			c.Error(&HTTPError{400, "invalid digest"})
+			return
+		}
+
+		ctx := metadata.AppendToOutgoingContext(c.Request.Context(), "path", path, "mode", mode.String(), "revision", revision)
+		if digest != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "sha256", digest)
+		}
 		stream, err := client.Upload(ctx)
 		if err != nil {
 			c.Error(err)
-            return
+			return
 		}
 		defer stream.CloseSend()
 
 		w := new(pb.StreamWriter)
 		w.StorageService_UploadClient(stream)
 		_, err = io.Copy(w, file)
-		if err != nil && !errors.Is(err, io.EOF){
+		if err != nil && !errors.Is(err, io.EOF) {
 			c.Error(err)
-            return
+			return
 		}
 
-		_, err = stream.CloseAndRecv()
+		response, err := stream.CloseAndRecv()
+		if err != nil && conditional {
+			err = preconditionError(err)
+		}
 		if err != nil {
-            c.Error(err)
+			c.Error(err)
+			return
 		}
+		c.Header("ETag", etag(response.Sha256, response.Revision))
+		c.JSON(200, response)
 	}
 }
 
-
 func ErrorHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
@@ -193,12 +582,26 @@ func ErrorHandler() gin.HandlerFunc {
 		if ok {
 			var httpCode int
 			switch stat.Code() {
+			case codes.InvalidArgument:
+				httpCode = 400
+			case codes.Unauthenticated:
+				httpCode = 401
+			case codes.PermissionDenied:
+				httpCode = 403
 			case codes.NotFound:
 				httpCode = 404
 			case codes.AlreadyExists:
 				httpCode = 409
 			case codes.FailedPrecondition:
 				httpCode = 409
+			case codes.Aborted:
+				httpCode = 423
+			case codes.ResourceExhausted:
+				httpCode = 413
+			case codes.DataLoss:
+				httpCode = 400
+			case codes.Unimplemented:
+				httpCode = 501
 			default:
 				httpCode = 500
 			}
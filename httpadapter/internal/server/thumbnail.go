@@ -0,0 +1,42 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// Thumbnail serves preview of image at "path" fitting "size" pixels, 128 by
+// default. It's cached by browser and revalidated by ETag, which changes
+// with file.
+func Thumbnail(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Query("path")
+		if path == "" {
+			c.Error(&HTTPError{400, "path missing"})
+			return
+		}
+		size, err := strconv.Atoi(c.DefaultQuery("size", "128"))
+		if err != nil {
+			c.Error(&HTTPError{400, "invalid size"})
+			return
+		}
+
+		request := &pb.ThumbnailRequest{Path: path, Size: int32(size)}
+		response, err := client.Thumbnail(c.Request.Context(), request)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.Header("ETag", strconv.Quote(response.Revision+"-"+strconv.Itoa(size)))
+		c.Header("Last-Modified", httpDate(response.ModTime))
+		c.Header("Cache-Control", "private, max-age=60")
+		if code := checkPreconditions(c); code != 0 {
+			respondPrecondition(c, code)
+			return
+		}
+		c.Data(200, response.MimeType, response.Data)
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// tus 1.0 resumable uploads, see https://tus.io/protocols/resumable-upload
+const tusVersion = "1.0.0"
+
+// check Tus-Resumable of request and set it in response
+func TusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.Request.Method != "OPTIONS" && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.Error(&HTTPError{412, "unsupported tus version"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func TusOptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Version", tusVersion)
+		c.Header("Tus-Extension", "creation,expiration,termination")
+		c.Status(204)
+	}
+}
+
+// TusCreate creates upload of file at "path" query parameter or "path"
+// metadata, "mode", "revision" and Digest header are same as in Upload
+func TusCreate(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Upload-Defer-Length") != "" {
+			c.Error(&HTTPError{400, "deferred length is not supported"})
+			return
+		}
+		size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || size < 0 {
+			c.Error(&HTTPError{400, "invalid Upload-Length"})
+			return
+		}
+		meta, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+		if err != nil {
+			c.Error(&HTTPError{400, "invalid Upload-Metadata"})
+			return
+		}
+		path := c.DefaultQuery("path", meta["path"])
+		if path == "" {
+			c.Error(&HTTPError{400, "path missing"})
+			return
+		}
+		mode, ok := writeModes[c.DefaultQuery("mode", "create")]
+		if !ok {
+			c.Error(&HTTPError{400, "unknown mode"})
+			return
+		}
+		digest, err := parseDigest(c.GetHeader("Digest"))
+		if err != nil {
+			c.Error(&HTTPError{400, "invalid digest"})
+			return
+		}
+
+		request := &pb.CreateUploadRequest{
+			Path:     path,
+			Size:     size,
+			Mode:     mode,
+			Revision: c.Query("revision"),
+			Sha256:   digest,
+		}
+		response, err := client.CreateUpload(c.Request.Context(), request)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		// relative to upload endpoint, it can be served under prefix
+		c.Header("Location", response.Id)
+		setUploadHeaders(c, response)
+		c.Status(201)
+	}
+}
+
+func TusHead(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response, err := client.UploadStatus(c.Request.Context(), &pb.UploadStatusRequest{Id: c.Param("id")})
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.Header("Upload-Length", strconv.FormatInt(response.Size, 10))
+		c.Header("Cache-Control", "no-store")
+		setUploadHeaders(c, response)
+		c.Status(200)
+	}
+}
+
+// TusPatch appends body at Upload-Offset. File is written when last byte
+// is appended, received part of broken body is kept.
+func TusPatch(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.ContentType() != "application/offset+octet-stream" {
+			c.Error(&HTTPError{415, "invalid Content-Type"})
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			c.Error(&HTTPError{400, "invalid Upload-Offset"})
+			return
+		}
+
+		ctx := metadata.AppendToOutgoingContext(c.Request.Context(), "id", c.Param("id"), "offset", strconv.FormatInt(offset, 10))
+		stream, err := client.AppendUpload(ctx)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		w := new(pb.StreamWriter)
+		w.StorageService_AppendUploadClient(stream)
+		_, copyErr := io.Copy(w, c.Request.Body)
+		// close instead of cancel, so storage keeps data received so far
+		response, err := stream.CloseAndRecv()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if copyErr != nil && !errors.Is(copyErr, io.EOF) {
+			c.Error(&HTTPError{400, "can't read body"})
+			return
+		}
+		setUploadHeaders(c, response)
+		c.Status(204)
+	}
+}
+
+func TusDelete(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, err := client.AbortUpload(c.Request.Context(), &pb.AbortUploadRequest{Id: c.Param("id")})
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.Status(204)
+	}
+}
+
+func setUploadHeaders(c *gin.Context, response *pb.UploadStatusResponse) {
+	c.Header("Upload-Offset", strconv.FormatInt(response.Offset, 10))
+	if response.Result != nil {
+		c.Header("X-Revision", response.Result.Revision)
+		c.Header("ETag", etag(response.Result.Sha256, response.Result.Revision))
+		return
+	}
+	c.Header("Upload-Expires", httpDate(response.Expires))
+}
+
+// parse "key base64value,key2" of Upload-Metadata
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
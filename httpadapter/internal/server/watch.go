@@ -0,0 +1,98 @@
+package server
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// keeps idle stream open through proxies
+const watchPingInterval = 30 * time.Second
+
+// Watch relays changes of "path", "recursive" also deeper ones, as
+// Server-Sent Events. Event name is change type like "create", data is JSON
+// with "path", "old_path" of move and "dir". Stream ends with "error" event
+// when storage stops it, e.g. when browser reads too slow.
+func Watch(client pb.StorageServiceClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.DefaultQuery("path", "/")
+		recursive, _ := strconv.ParseBool(c.Query("recursive"))
+
+		ctx := c.Request.Context()
+		stream, err := client.Watch(ctx, &pb.WatchRequest{Path: path, Recursive: recursive})
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		// header is sent once watching started, errors of request come
+		// instead of it with trailer
+		md, err := stream.Header()
+		if err == nil && md == nil {
+			_, err = stream.Recv()
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		events := make(chan *pb.WatchEvent)
+		errc := make(chan error, 1)
+		go func() {
+			for {
+				event, err := stream.Recv()
+				if err != nil {
+					errc <- err
+					return
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		// don't buffer stream in nginx
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(200)
+		c.Writer.Flush()
+		ping := time.NewTicker(watchPingInterval)
+		defer ping.Stop()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event := <-events:
+				c.SSEvent(eventType(event), gin.H{
+					"path":     event.Path,
+					"old_path": event.OldPath,
+					"dir":      event.Dir,
+				})
+				return true
+			case <-ping.C:
+				// comment line, ignored by EventSource
+				_, err := io.WriteString(w, ": ping\n\n")
+				return err == nil
+			case err := <-errc:
+				if status.Code(err) != codes.Canceled {
+					c.SSEvent("error", gin.H{"message": status.Convert(err).Message()})
+				}
+				return false
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}
+}
+
+// name of event type like "create"
+func eventType(event *pb.WatchEvent) string {
+	return strings.ToLower(event.Type.String())
+}
@@ -25,8 +25,45 @@ type StorageServiceClient interface {
 	Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*MkdirResponse, error)
 	ReadDir(ctx context.Context, in *ReadDirRequest, opts ...grpc.CallOption) (*ReadDirResponse, error)
 	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error)
+	RemoveAll(ctx context.Context, in *RemoveAllRequest, opts ...grpc.CallOption) (*RemoveAllResponse, error)
+	Move(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*MoveResponse, error)
+	Copy(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (StorageService_CopyClient, error)
+	// unpack archive into directory. Names can't lead out of directory, limits
+	// of size and number of entries fail with ResourceExhausted. Zip on
+	// backend without random access, like S3, is copied to temporary file and
+	// must itself fit in size limit. On error already extracted entries stay.
+	Extract(ctx context.Context, in *ExtractRequest, opts ...grpc.CallOption) (*ExtractResponse, error)
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*StatResponse, error)
+	// preview of JPEG, PNG, GIF or WebP image, other files fail with
+	// FailedPrecondition
+	Thumbnail(ctx context.Context, in *ThumbnailRequest, opts ...grpc.CallOption) (*ThumbnailResponse, error)
+	Walk(ctx context.Context, in *WalkRequest, opts ...grpc.CallOption) (StorageService_WalkClient, error)
+	// entries under path matching request, in order of Walk
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (StorageService_SearchClient, error)
+	// lines of indexed text files, Unimplemented if index is disabled
+	SearchContent(ctx context.Context, in *SearchContentRequest, opts ...grpc.CallOption) (*SearchContentResponse, error)
+	// changes of path and entries under it, made through the service or,
+	// where backend notices them, past it. Header metadata is sent once
+	// watching started. Watcher falling behind fails with ResourceExhausted,
+	// Unimplemented if events are disabled.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (StorageService_WatchClient, error)
+	// changes since cursor kept in journal on storage node, made through the
+	// service or noticed like Watch. Unimplemented if journal is disabled.
+	ListChanges(ctx context.Context, in *ListChangesRequest, opts ...grpc.CallOption) (*ListChangesResponse, error)
+	// file is "path" metadata, header metadata has "name", "size" of whole
+	// file, "revision", "mod_time" in unix milliseconds, "mime_type" and
+	// "sha256" if digest is stored. Archive of directory has only "name" and "mod_time".
 	Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (StorageService_DownloadClient, error)
+	// file is "path" metadata, optional "sha256" metadata is expected digest,
+	// upload fails with DataLoss if received data doesn't match it
 	Upload(ctx context.Context, opts ...grpc.CallOption) (StorageService_UploadClient, error)
+	// resumable uploads, Unimplemented if staging is disabled
+	CreateUpload(ctx context.Context, in *CreateUploadRequest, opts ...grpc.CallOption) (*UploadStatusResponse, error)
+	UploadStatus(ctx context.Context, in *UploadStatusRequest, opts ...grpc.CallOption) (*UploadStatusResponse, error)
+	// upload is "id" metadata, "offset" metadata must equal its offset. Data
+	// received before broken stream is kept.
+	AppendUpload(ctx context.Context, opts ...grpc.CallOption) (StorageService_AppendUploadClient, error)
+	AbortUpload(ctx context.Context, in *AbortUploadRequest, opts ...grpc.CallOption) (*AbortUploadResponse, error)
 }
 
 type storageServiceClient struct {
@@ -64,8 +101,199 @@ func (c *storageServiceClient) Remove(ctx context.Context, in *RemoveRequest, op
 	return out, nil
 }
 
+func (c *storageServiceClient) RemoveAll(ctx context.Context, in *RemoveAllRequest, opts ...grpc.CallOption) (*RemoveAllResponse, error) {
+	out := new(RemoveAllResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/RemoveAll", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) Move(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*MoveResponse, error) {
+	out := new(MoveResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/Move", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) Copy(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (StorageService_CopyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[0], "/StorageService/Copy", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageServiceCopyClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StorageService_CopyClient interface {
+	Recv() (*CopyResponse, error)
+	grpc.ClientStream
+}
+
+type storageServiceCopyClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageServiceCopyClient) Recv() (*CopyResponse, error) {
+	m := new(CopyResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storageServiceClient) Extract(ctx context.Context, in *ExtractRequest, opts ...grpc.CallOption) (*ExtractResponse, error) {
+	out := new(ExtractResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/Extract", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*StatResponse, error) {
+	out := new(StatResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/Stat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) Thumbnail(ctx context.Context, in *ThumbnailRequest, opts ...grpc.CallOption) (*ThumbnailResponse, error) {
+	out := new(ThumbnailResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/Thumbnail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) Walk(ctx context.Context, in *WalkRequest, opts ...grpc.CallOption) (StorageService_WalkClient, error) {
+	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[1], "/StorageService/Walk", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageServiceWalkClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StorageService_WalkClient interface {
+	Recv() (*WalkResponse, error)
+	grpc.ClientStream
+}
+
+type storageServiceWalkClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageServiceWalkClient) Recv() (*WalkResponse, error) {
+	m := new(WalkResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storageServiceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (StorageService_SearchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[2], "/StorageService/Search", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageServiceSearchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StorageService_SearchClient interface {
+	Recv() (*WalkResponse, error)
+	grpc.ClientStream
+}
+
+type storageServiceSearchClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageServiceSearchClient) Recv() (*WalkResponse, error) {
+	m := new(WalkResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storageServiceClient) SearchContent(ctx context.Context, in *SearchContentRequest, opts ...grpc.CallOption) (*SearchContentResponse, error) {
+	out := new(SearchContentResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/SearchContent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (StorageService_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[3], "/StorageService/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageServiceWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StorageService_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type storageServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageServiceWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storageServiceClient) ListChanges(ctx context.Context, in *ListChangesRequest, opts ...grpc.CallOption) (*ListChangesResponse, error) {
+	out := new(ListChangesResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/ListChanges", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *storageServiceClient) Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (StorageService_DownloadClient, error) {
-	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[0], "/StorageService/Download", opts...)
+	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[4], "/StorageService/Download", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +325,7 @@ func (x *storageServiceDownloadClient) Recv() (*DownloadResponse, error) {
 }
 
 func (c *storageServiceClient) Upload(ctx context.Context, opts ...grpc.CallOption) (StorageService_UploadClient, error) {
-	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[1], "/StorageService/Upload", opts...)
+	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[5], "/StorageService/Upload", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -130,6 +358,67 @@ func (x *storageServiceUploadClient) CloseAndRecv() (*UploadResponse, error) {
 	return m, nil
 }
 
+func (c *storageServiceClient) CreateUpload(ctx context.Context, in *CreateUploadRequest, opts ...grpc.CallOption) (*UploadStatusResponse, error) {
+	out := new(UploadStatusResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/CreateUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) UploadStatus(ctx context.Context, in *UploadStatusRequest, opts ...grpc.CallOption) (*UploadStatusResponse, error) {
+	out := new(UploadStatusResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/UploadStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) AppendUpload(ctx context.Context, opts ...grpc.CallOption) (StorageService_AppendUploadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[6], "/StorageService/AppendUpload", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageServiceAppendUploadClient{stream}
+	return x, nil
+}
+
+type StorageService_AppendUploadClient interface {
+	Send(*UploadRequest) error
+	CloseAndRecv() (*UploadStatusResponse, error)
+	grpc.ClientStream
+}
+
+type storageServiceAppendUploadClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageServiceAppendUploadClient) Send(m *UploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *storageServiceAppendUploadClient) CloseAndRecv() (*UploadStatusResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadStatusResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storageServiceClient) AbortUpload(ctx context.Context, in *AbortUploadRequest, opts ...grpc.CallOption) (*AbortUploadResponse, error) {
+	out := new(AbortUploadResponse)
+	err := c.cc.Invoke(ctx, "/StorageService/AbortUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StorageServiceServer is the server API for StorageService service.
 // All implementations should embed UnimplementedStorageServiceServer
 // for forward compatibility
@@ -137,8 +426,45 @@ type StorageServiceServer interface {
 	Mkdir(context.Context, *MkdirRequest) (*MkdirResponse, error)
 	ReadDir(context.Context, *ReadDirRequest) (*ReadDirResponse, error)
 	Remove(context.Context, *RemoveRequest) (*RemoveResponse, error)
+	RemoveAll(context.Context, *RemoveAllRequest) (*RemoveAllResponse, error)
+	Move(context.Context, *MoveRequest) (*MoveResponse, error)
+	Copy(*CopyRequest, StorageService_CopyServer) error
+	// unpack archive into directory. Names can't lead out of directory, limits
+	// of size and number of entries fail with ResourceExhausted. Zip on
+	// backend without random access, like S3, is copied to temporary file and
+	// must itself fit in size limit. On error already extracted entries stay.
+	Extract(context.Context, *ExtractRequest) (*ExtractResponse, error)
+	Stat(context.Context, *StatRequest) (*StatResponse, error)
+	// preview of JPEG, PNG, GIF or WebP image, other files fail with
+	// FailedPrecondition
+	Thumbnail(context.Context, *ThumbnailRequest) (*ThumbnailResponse, error)
+	Walk(*WalkRequest, StorageService_WalkServer) error
+	// entries under path matching request, in order of Walk
+	Search(*SearchRequest, StorageService_SearchServer) error
+	// lines of indexed text files, Unimplemented if index is disabled
+	SearchContent(context.Context, *SearchContentRequest) (*SearchContentResponse, error)
+	// changes of path and entries under it, made through the service or,
+	// where backend notices them, past it. Header metadata is sent once
+	// watching started. Watcher falling behind fails with ResourceExhausted,
+	// Unimplemented if events are disabled.
+	Watch(*WatchRequest, StorageService_WatchServer) error
+	// changes since cursor kept in journal on storage node, made through the
+	// service or noticed like Watch. Unimplemented if journal is disabled.
+	ListChanges(context.Context, *ListChangesRequest) (*ListChangesResponse, error)
+	// file is "path" metadata, header metadata has "name", "size" of whole
+	// file, "revision", "mod_time" in unix milliseconds, "mime_type" and
+	// "sha256" if digest is stored. Archive of directory has only "name" and "mod_time".
 	Download(*DownloadRequest, StorageService_DownloadServer) error
+	// file is "path" metadata, optional "sha256" metadata is expected digest,
+	// upload fails with DataLoss if received data doesn't match it
 	Upload(StorageService_UploadServer) error
+	// resumable uploads, Unimplemented if staging is disabled
+	CreateUpload(context.Context, *CreateUploadRequest) (*UploadStatusResponse, error)
+	UploadStatus(context.Context, *UploadStatusRequest) (*UploadStatusResponse, error)
+	// upload is "id" metadata, "offset" metadata must equal its offset. Data
+	// received before broken stream is kept.
+	AppendUpload(StorageService_AppendUploadServer) error
+	AbortUpload(context.Context, *AbortUploadRequest) (*AbortUploadResponse, error)
 }
 
 // UnimplementedStorageServiceServer should be embedded to have forward compatible implementations.
@@ -154,12 +480,57 @@ func (UnimplementedStorageServiceServer) ReadDir(context.Context, *ReadDirReques
 func (UnimplementedStorageServiceServer) Remove(context.Context, *RemoveRequest) (*RemoveResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
 }
+func (UnimplementedStorageServiceServer) RemoveAll(context.Context, *RemoveAllRequest) (*RemoveAllResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveAll not implemented")
+}
+func (UnimplementedStorageServiceServer) Move(context.Context, *MoveRequest) (*MoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Move not implemented")
+}
+func (UnimplementedStorageServiceServer) Copy(*CopyRequest, StorageService_CopyServer) error {
+	return status.Errorf(codes.Unimplemented, "method Copy not implemented")
+}
+func (UnimplementedStorageServiceServer) Extract(context.Context, *ExtractRequest) (*ExtractResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Extract not implemented")
+}
+func (UnimplementedStorageServiceServer) Stat(context.Context, *StatRequest) (*StatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedStorageServiceServer) Thumbnail(context.Context, *ThumbnailRequest) (*ThumbnailResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Thumbnail not implemented")
+}
+func (UnimplementedStorageServiceServer) Walk(*WalkRequest, StorageService_WalkServer) error {
+	return status.Errorf(codes.Unimplemented, "method Walk not implemented")
+}
+func (UnimplementedStorageServiceServer) Search(*SearchRequest, StorageService_SearchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedStorageServiceServer) SearchContent(context.Context, *SearchContentRequest) (*SearchContentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchContent not implemented")
+}
+func (UnimplementedStorageServiceServer) Watch(*WatchRequest, StorageService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedStorageServiceServer) ListChanges(context.Context, *ListChangesRequest) (*ListChangesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListChanges not implemented")
+}
 func (UnimplementedStorageServiceServer) Download(*DownloadRequest, StorageService_DownloadServer) error {
 	return status.Errorf(codes.Unimplemented, "method Download not implemented")
 }
 func (UnimplementedStorageServiceServer) Upload(StorageService_UploadServer) error {
 	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
 }
+func (UnimplementedStorageServiceServer) CreateUpload(context.Context, *CreateUploadRequest) (*UploadStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateUpload not implemented")
+}
+func (UnimplementedStorageServiceServer) UploadStatus(context.Context, *UploadStatusRequest) (*UploadStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UploadStatus not implemented")
+}
+func (UnimplementedStorageServiceServer) AppendUpload(StorageService_AppendUploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method AppendUpload not implemented")
+}
+func (UnimplementedStorageServiceServer) AbortUpload(context.Context, *AbortUploadRequest) (*AbortUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AbortUpload not implemented")
+}
 
 // UnsafeStorageServiceServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to StorageServiceServer will
@@ -226,6 +597,216 @@ func _StorageService_Remove_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _StorageService_RemoveAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveAllRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).RemoveAll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/RemoveAll",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).RemoveAll(ctx, req.(*RemoveAllRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_Move_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).Move(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/Move",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).Move(ctx, req.(*MoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_Copy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CopyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServiceServer).Copy(m, &storageServiceCopyServer{stream})
+}
+
+type StorageService_CopyServer interface {
+	Send(*CopyResponse) error
+	grpc.ServerStream
+}
+
+type storageServiceCopyServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageServiceCopyServer) Send(m *CopyResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _StorageService_Extract_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtractRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).Extract(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/Extract",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).Extract(ctx, req.(*ExtractRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/Stat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_Thumbnail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ThumbnailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).Thumbnail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/Thumbnail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).Thumbnail(ctx, req.(*ThumbnailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_Walk_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WalkRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServiceServer).Walk(m, &storageServiceWalkServer{stream})
+}
+
+type StorageService_WalkServer interface {
+	Send(*WalkResponse) error
+	grpc.ServerStream
+}
+
+type storageServiceWalkServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageServiceWalkServer) Send(m *WalkResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _StorageService_Search_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServiceServer).Search(m, &storageServiceSearchServer{stream})
+}
+
+type StorageService_SearchServer interface {
+	Send(*WalkResponse) error
+	grpc.ServerStream
+}
+
+type storageServiceSearchServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageServiceSearchServer) Send(m *WalkResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _StorageService_SearchContent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchContentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).SearchContent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/SearchContent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).SearchContent(ctx, req.(*SearchContentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServiceServer).Watch(m, &storageServiceWatchServer{stream})
+}
+
+type StorageService_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type storageServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageServiceWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _StorageService_ListChanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListChangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).ListChanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/ListChanges",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).ListChanges(ctx, req.(*ListChangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _StorageService_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(DownloadRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -273,6 +854,86 @@ func (x *storageServiceUploadServer) Recv() (*UploadRequest, error) {
 	return m, nil
 }
 
+func _StorageService_CreateUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).CreateUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/CreateUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).CreateUpload(ctx, req.(*CreateUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_UploadStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).UploadStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/UploadStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).UploadStatus(ctx, req.(*UploadStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_AppendUpload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StorageServiceServer).AppendUpload(&storageServiceAppendUploadServer{stream})
+}
+
+type StorageService_AppendUploadServer interface {
+	SendAndClose(*UploadStatusResponse) error
+	Recv() (*UploadRequest, error)
+	grpc.ServerStream
+}
+
+type storageServiceAppendUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageServiceAppendUploadServer) SendAndClose(m *UploadStatusResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *storageServiceAppendUploadServer) Recv() (*UploadRequest, error) {
+	m := new(UploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _StorageService_AbortUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AbortUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).AbortUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/StorageService/AbortUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).AbortUpload(ctx, req.(*AbortUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // StorageService_ServiceDesc is the grpc.ServiceDesc for StorageService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -292,8 +953,68 @@ var StorageService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Remove",
 			Handler:    _StorageService_Remove_Handler,
 		},
+		{
+			MethodName: "RemoveAll",
+			Handler:    _StorageService_RemoveAll_Handler,
+		},
+		{
+			MethodName: "Move",
+			Handler:    _StorageService_Move_Handler,
+		},
+		{
+			MethodName: "Extract",
+			Handler:    _StorageService_Extract_Handler,
+		},
+		{
+			MethodName: "Stat",
+			Handler:    _StorageService_Stat_Handler,
+		},
+		{
+			MethodName: "Thumbnail",
+			Handler:    _StorageService_Thumbnail_Handler,
+		},
+		{
+			MethodName: "SearchContent",
+			Handler:    _StorageService_SearchContent_Handler,
+		},
+		{
+			MethodName: "ListChanges",
+			Handler:    _StorageService_ListChanges_Handler,
+		},
+		{
+			MethodName: "CreateUpload",
+			Handler:    _StorageService_CreateUpload_Handler,
+		},
+		{
+			MethodName: "UploadStatus",
+			Handler:    _StorageService_UploadStatus_Handler,
+		},
+		{
+			MethodName: "AbortUpload",
+			Handler:    _StorageService_AbortUpload_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Copy",
+			Handler:       _StorageService_Copy_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Walk",
+			Handler:       _StorageService_Walk_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Search",
+			Handler:       _StorageService_Search_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _StorageService_Watch_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "Download",
 			Handler:       _StorageService_Download_Handler,
@@ -304,6 +1025,11 @@ var StorageService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _StorageService_Upload_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "AppendUpload",
+			Handler:       _StorageService_AppendUpload_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "storage.proto",
 }
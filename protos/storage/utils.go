@@ -1,5 +1,12 @@
 package storage
 
+// metadata of user authenticated by httpadapter, claims are JSON of claims
+// of user token
+const (
+	SubjectMD = "auth-subject"
+	ClaimsMD  = "auth-claims-bin"
+)
+
 // adapter stream to io.Write interface
 type StreamWriter struct {
 	callback func([]byte) (int, error)
@@ -27,6 +34,15 @@ func (w *StreamWriter) StorageService_UploadClient(stream StorageService_UploadC
 		return len(b), err
 	}
 }
+func (w *StreamWriter) StorageService_AppendUploadClient(stream StorageService_AppendUploadClient) {
+	w.callback = func(b []byte) (int, error) {
+		request := &UploadRequest{
+			Chunk: b,
+		}
+		err := stream.Send(request)
+		return len(b), err
+	}
+}
 
 // adapter stream to io.Reader interface
 type StreamReader struct {
@@ -37,16 +53,20 @@ func (r *StreamReader) Read(b []byte) (int, error) {
 	return r.callback(b)
 }
 
+// chunk can be bigger than b, rest of it is returned by next reads
 func (r *StreamReader) StorageService_DownloadClient(stream StorageService_DownloadClient) {
+	var rest []byte
 	r.callback = func(b []byte) (int, error) {
-		response, err := stream.Recv()
-		if err != nil {
-			return 0, err
+		for len(rest) == 0 {
+			response, err := stream.Recv()
+			if err != nil {
+				return 0, err
+			}
+			rest = response.Chunk
 		}
-		for i := 0; i < len(response.Chunk); i++ {
-			b[i] = response.Chunk[i]
-		}
-		return len(response.Chunk), nil
+		n := copy(b, rest)
+		rest = rest[n:]
+		return n, nil
 	}
 }
 
@@ -62,3 +82,21 @@ func (r *StreamReader) StorageService_UploadServer(stream StorageService_UploadS
 		return len(request.Chunk), nil
 	}
 }
+
+// chunk can be bigger than b, e.g. under io.LimitReader, rest of it is
+// returned by next reads
+func (r *StreamReader) StorageService_AppendUploadServer(stream StorageService_AppendUploadServer) {
+	var rest []byte
+	r.callback = func(b []byte) (int, error) {
+		for len(rest) == 0 {
+			request, err := stream.Recv()
+			if err != nil {
+				return 0, err
+			}
+			rest = request.Chunk
+		}
+		n := copy(b, rest)
+		rest = rest[n:]
+		return n, nil
+	}
+}
@@ -1,6 +1,23 @@
 import {useEffect, useState} from 'react'
 import './App.css'
 
+// Formatting
+
+function formatSize(size) {
+    const units = ["B", "KB", "MB", "GB", "TB"]
+    let i = 0
+    size = size || 0
+    while (size >= 1024 && i < units.length - 1) {
+        size /= 1024
+        i++
+    }
+    return (i == 0 ? size : size.toFixed(1)) + " " + units[i]
+}
+
+function formatDate(modTime) {
+    return modTime ? new Date(modTime).toLocaleString() : ""
+}
+
 // Icons
 
 function FolderIcon() {
@@ -13,6 +30,21 @@ function FileIcon() {
         <svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 384 512"><path d="M0 64C0 28.7 28.7 0 64 0H224V128c0 17.7 14.3 32 32 32H384V448c0 35.3-28.7 64-64 64H64c-35.3 0-64-28.7-64-64V64zm384 64H256V0L384 128z" /></svg>
     )
 }
+
+const imageExtensions = [".jpg", ".jpeg", ".png", ".gif", ".webp"]
+
+// thumbnail of image, icon for other files and images without thumbnail
+function FilePreview({file}) {
+    const [failed, setFailed] = useState(false)
+    const name = file.name.toLowerCase()
+    if (failed || !imageExtensions.some(ext => name.endsWith(ext))) {
+        return <FileIcon />
+    }
+    // mod_time changes url, so changed file isn't shown from browser cache
+    const params = new URLSearchParams({path: file.path, size: 64, t: file.mod_time || 0})
+    return <img className="thumbnail" src={"/api/thumbnail/?" + params} onError={() => setFailed(true)} />
+}
+
 function DeleteIcon({callback}) {
     return (
         <svg onClick={callback} xmlns="http://www.w3.org/2000/svg" viewBox="0 0 448 512"><path d="M135.2 17.7L128 32H32C14.3 32 0 46.3 0 64S14.3 96 32 96H416c17.7 0 32-14.3 32-32s-14.3-32-32-32H320l-7.2-14.3C307.4 6.8 296.3 0 284.2 0H163.8c-12.1 0-23.2 6.8-28.6 17.7zM416 128H32L53.2 467c1.6 25.3 22.6 45 47.9 45H346.9c25.3 0 46.3-19.7 47.9-45L416 128z" /></svg>
@@ -52,6 +84,59 @@ function MkdirBar({currentPath, updateEntry}) {
     )
 }
 
+function SearchBar({currentPath, setResults}) {
+    const [name, setName] = useState("")
+
+    const handleChange = function (e) {
+        setName(e.target.value)
+    }
+
+    const search = async function (e) {
+        if (name == "") {
+            setResults(null)
+            return
+        }
+        const params = new URLSearchParams({path: currentPath, glob: "*" + name + "*", limit: 100})
+        let response = await fetch("/api/search/?" + params)
+        if (response.status != 200) {
+            alert("Sory something went wrong")
+            return
+        }
+        // one json object per line
+        let text = await response.text()
+        let results = text.split("\n").filter(line => line != "").map(line => JSON.parse(line))
+        setResults(results.filter(result => result.error == undefined))
+    }
+
+    return (
+        <>
+            <input type="text" onChange={handleChange} />
+            <button onClick={search}>search</button>
+        </>
+    )
+}
+
+function SearchItem({setCurrentPath, setResults, result}) {
+    const open = function () {
+        if (result.dir) {
+            setResults(null)
+            setCurrentPath(result.path)
+            return
+        }
+        const link = document.createElement('a');
+        link.href = "/api/download/?path=" + result.path;
+        document.body.appendChild(link);
+        link.click();
+        document.body.removeChild(link);
+    }
+    return (
+        <li onClick={open} className="board-item dir">
+            {result.dir ? <FolderIcon /> : <FileIcon />} <p>{result.path}</p>
+            {!result.dir && <span className="meta">{formatSize(result.size)}</span>}
+        </li>
+    )
+}
+
 function UploadBar({currentPath, updateEntry}) {
     const [file, setFile] = useState(null);
     const [status, setStatus] = useState(0)
@@ -65,13 +150,20 @@ function UploadBar({currentPath, updateEntry}) {
     const upload = async function (e) {
         setStatus(1)
 
-        let formData = new FormData();
-        formData.append("file", file)
-        let options = {
-            method: 'POST',
-            body: formData
+        const send = async function (mode) {
+            let formData = new FormData();
+            formData.append("file", file)
+            let options = {
+                method: 'POST',
+                body: formData
+            }
+            return await fetch("/api/upload/?path=" + currentPath + "/" + file.name + "&mode=" + mode, options)
+        }
+
+        let response = await send("create")
+        if (response.status == 409 && confirm("File " + file.name + " already exist, replace it?")) {
+            response = await send("overwrite")
         }
-        let response = await fetch("/api/upload/?path=" + currentPath + "/" + file.name, options)
         if (response.status == 200) {
             updateEntry();
         } else if (response.status == 409) {
@@ -116,7 +208,12 @@ function DirItem({setCurrentPath, updateEntry, dir}) {
         if (response.status == 200) {
             updateEntry();
         } else if (response.status == 409) {
-            alert("Directory not empty")
+            if (confirm("Directory " + dir.name + " not empty, remove it with all content?")) {
+                response = await fetch("/api/removeall/", options)
+                if (response.status != 200) {
+                    alert("Sory something went wrong");
+                }
+            }
         } else {
             alert("Sory something went wrong");
         }
@@ -125,7 +222,10 @@ function DirItem({setCurrentPath, updateEntry, dir}) {
     }
     return (
         <li onClick={() => setCurrentPath(dir.path)} key={dir.path} className="board-item dir">
-            <FolderIcon /> <p>{dir.name}</p> <DeleteIcon callback={remove} />
+            <FolderIcon /> <p>{dir.name}</p>
+            <span className="meta">{(dir.files || 0) + (dir.dirs || 0)} items</span>
+            <span className="meta">{formatDate(dir.mod_time)}</span>
+            <DeleteIcon callback={remove} />
         </li>
     )
 }
@@ -167,7 +267,10 @@ function FileItem({updateEntry, file}) {
     }
     return (
         <li onClick={download} key={file.path} className="board-item dir">
-            <FileIcon /> <p>{file.name}</p> <DeleteIcon callback={remove} />
+            <FilePreview file={file} /> <p>{file.name}</p>
+            <span className="meta">{formatSize(file.size)}</span>
+            <span className="meta">{formatDate(file.mod_time)}</span>
+            <DeleteIcon callback={remove} />
         </li>
     )
 }
@@ -178,6 +281,8 @@ function App() {
 
     const [dirs, setDirs] = useState([])
     const [files, setFiles] = useState([])
+    // search results replace listing until search is cleared
+    const [results, setResults] = useState(null)
 
     const updateEntry = async function () {
         let options = {
@@ -208,6 +313,14 @@ function App() {
     useEffect(() => {
         updateEntry()
     }, [currentPath])
+    // update entry if something changes in current path
+    useEffect(() => {
+        const source = new EventSource("/api/watch/?" + new URLSearchParams({path: currentPath}))
+        for (const type of ["create", "remove", "move", "modify"]) {
+            source.addEventListener(type, () => updateEntry())
+        }
+        return () => source.close()
+    }, [currentPath])
     // update parent path if change path
     useEffect(() => {
         if (currentPath == "/") {
@@ -234,6 +347,22 @@ function App() {
                     <UploadBar currentPath={currentPath} updateEntry={updateEntry} />
                 </div>
 
+                <div className="board-bar">
+                    <SearchBar currentPath={currentPath} setResults={setResults} />
+                </div>
+
+                {results != null &&
+                    <div className="board-list">
+                        <h4>found in {currentPath}: {results.length} <button onClick={() => setResults(null)}>clear</button></h4>
+                        <ul>
+                            {results.map(result => (
+                                <SearchItem key={result.path} setCurrentPath={setCurrentPath} setResults={setResults} result={result} />
+                            ))}
+                        </ul>
+                    </div>
+                }
+
+                {results == null &&
                 <div className="board-list">
                     <h4>path: {currentPath}</h4>
                     <ul>
@@ -251,6 +380,7 @@ function App() {
                         ))}
                     </ul>
                 </div>
+                }
             </div>
         </>
     )
@@ -1,15 +1,119 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
 	"github.com/caarlos0/env/v8"
 
+	"github.com/muskelo/ns_server/storage/internal/backend"
+	"github.com/muskelo/ns_server/storage/internal/events"
 	"github.com/muskelo/ns_server/storage/internal/filemanager"
+	"github.com/muskelo/ns_server/storage/internal/index"
+	"github.com/muskelo/ns_server/storage/internal/journal"
+	"github.com/muskelo/ns_server/storage/internal/memory"
+	"github.com/muskelo/ns_server/storage/internal/s3"
 	"github.com/muskelo/ns_server/storage/internal/server"
+	"github.com/muskelo/ns_server/storage/internal/thumbnail"
+	"github.com/muskelo/ns_server/storage/internal/uploads"
 )
 
 type config struct {
+	Backend         string `env:"NS_STORAGE_BACKEND" envDefault:"local"`
 	FileManagerRoot string `env:"NS_STORAGE_FM_ROOT" envDefault:"/var/ns/default"`
 	Listen          string `env:"NS_STORAGE_LISTEN" envDefault:"0.0.0.0:5200"`
+
+	S3Endpoint  string `env:"NS_STORAGE_S3_ENDPOINT"`
+	S3Region    string `env:"NS_STORAGE_S3_REGION" envDefault:"us-east-1"`
+	S3Bucket    string `env:"NS_STORAGE_S3_BUCKET"`
+	S3AccessKey string `env:"NS_STORAGE_S3_ACCESS_KEY"`
+	S3SecretKey string `env:"NS_STORAGE_S3_SECRET_KEY"`
+	S3Prefix    string `env:"NS_STORAGE_S3_PREFIX"`
+	S3PartSize  int    `env:"NS_STORAGE_S3_PART_SIZE" envDefault:"8388608"`
+
+	Index bool `env:"NS_STORAGE_INDEX" envDefault:"true"`
+	// defaults like uploads dir with ".index", memory backend keeps index in
+	// memory
+	IndexPath string `env:"NS_STORAGE_INDEX_PATH"`
+
+	Uploads bool `env:"NS_STORAGE_UPLOADS" envDefault:"true"`
+	// staging of resumable uploads, defaults to "<root>.uploads" for local
+	// backend and temporary directory for others
+	UploadsDir string        `env:"NS_STORAGE_UPLOADS_DIR"`
+	UploadsTTL time.Duration `env:"NS_STORAGE_UPLOADS_TTL" envDefault:"24h"`
+
+	Thumbnails bool `env:"NS_STORAGE_THUMBNAILS" envDefault:"true"`
+	// cache of thumbnails, defaults like uploads dir with ".thumbnails"
+	ThumbnailsDir string `env:"NS_STORAGE_THUMBNAILS_DIR"`
+	// thumbnails not requested for it are removed
+	ThumbnailsTTL time.Duration `env:"NS_STORAGE_THUMBNAILS_TTL" envDefault:"720h"`
+
+	Watch bool `env:"NS_STORAGE_WATCH" envDefault:"true"`
+	// notice changes made past the service for Watch and journal, where
+	// backend supports it
+	WatchExternal bool `env:"NS_STORAGE_WATCH_EXTERNAL" envDefault:"true"`
+
+	Journal bool `env:"NS_STORAGE_JOURNAL" envDefault:"true"`
+	// defaults like uploads dir with ".journal", memory backend keeps
+	// journal in memory
+	JournalPath string `env:"NS_STORAGE_JOURNAL_PATH"`
+	// older changes are dropped and their cursors expire, 0 is unlimited
+	JournalRetention  time.Duration `env:"NS_STORAGE_JOURNAL_RETENTION" envDefault:"720h"`
+	JournalMaxEntries int           `env:"NS_STORAGE_JOURNAL_MAX_ENTRIES" envDefault:"100000"`
+
+	// limits of extracted archive, 0 is unlimited
+	ExtractMaxSize    int64 `env:"NS_STORAGE_EXTRACT_MAX_SIZE" envDefault:"1073741824"`
+	ExtractMaxEntries int   `env:"NS_STORAGE_EXTRACT_MAX_ENTRIES" envDefault:"10000"`
+}
+
+func newBackend(cfg config) (backend.Backend, error) {
+	switch cfg.Backend {
+	case "local":
+		return &filemanager.FileManager{
+			Root: cfg.FileManagerRoot,
+		}, nil
+	case "memory":
+		return memory.New(), nil
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, fmt.Errorf("NS_STORAGE_S3_ENDPOINT and NS_STORAGE_S3_BUCKET are required for s3 backend")
+		}
+		return &s3.S3{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			Prefix:    cfg.S3Prefix,
+			PartSize:  cfg.S3PartSize,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+}
+
+// remove expired entries now and then every interval
+func sweep(name string, s backend.Sweeper, interval time.Duration) {
+	for {
+		if err := s.Sweep(); err != nil {
+			log.Printf("sweep %v: %v", name, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// dir next to root of local backend, temporary directory for others
+func localDir(cfg config, suffix string) string {
+	if cfg.Backend == "local" {
+		return filepath.Clean(cfg.FileManagerRoot) + "." + suffix
+	}
+	return filepath.Join(os.TempDir(), "ns-"+suffix)
 }
 
 func main() {
@@ -17,13 +121,88 @@ func main() {
 	if err := env.Parse(&cfg); err != nil {
 		panic(err)
 	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	fm := &filemanager.FileManager{
-		Root: cfg.FileManagerRoot,
+	b, err := newBackend(cfg)
+	if err != nil {
+		panic(err)
+	}
+	if sweeper, ok := b.(backend.Sweeper); ok {
+		if err := sweeper.Sweep(); err != nil {
+			panic(err)
+		}
+	}
+	s := server.New(b)
+	s.MaxExtractSize = cfg.ExtractMaxSize
+	s.MaxExtractEntries = cfg.ExtractMaxEntries
+	if cfg.Index {
+		path := cfg.IndexPath
+		if path == "" && cfg.Backend != "memory" {
+			path = localDir(cfg, "index")
+		}
+		s.Index, err = index.Open(b, path)
+		if err != nil {
+			panic(err)
+		}
 	}
-	s := server.New(fm)
-	err := server.Serve(cfg.Listen, s)
+	if cfg.Uploads {
+		dir := cfg.UploadsDir
+		if dir == "" {
+			dir = localDir(cfg, "uploads")
+		}
+		s.Uploads, err = uploads.New(dir, cfg.UploadsTTL)
+		if err != nil {
+			panic(err)
+		}
+		go sweep("uploads", s.Uploads, time.Hour)
+	}
+	if cfg.Thumbnails {
+		dir := cfg.ThumbnailsDir
+		if dir == "" {
+			dir = localDir(cfg, "thumbnails")
+		}
+		s.Thumbnails, err = thumbnail.NewCache(dir, cfg.ThumbnailsTTL)
+		if err != nil {
+			panic(err)
+		}
+		go sweep("thumbnails", s.Thumbnails, time.Hour)
+	}
+	if cfg.Journal {
+		path := cfg.JournalPath
+		if path == "" && cfg.Backend != "memory" {
+			path = localDir(cfg, "journal")
+		}
+		s.Journal, err = journal.Open(path, cfg.JournalRetention, cfg.JournalMaxEntries)
+		if err != nil {
+			panic(err)
+		}
+		go sweep("journal", s.Journal, time.Hour)
+	}
+	if cfg.Watch {
+		s.Events = events.NewHub()
+		if watcher, ok := b.(backend.Watcher); ok && cfg.WatchExternal {
+			go func() {
+				if err := watcher.Watch(ctx, s.ExternalChange); err != nil {
+					log.Printf("watch backend: %v", err)
+				}
+			}()
+		}
+	}
+	err = server.Serve(ctx, cfg.Listen, s)
 	if err != nil {
 		panic(err)
 	}
+
+	// calls are done, save what they changed
+	if s.Index != nil {
+		if err := s.Index.Close(); err != nil {
+			log.Printf("close index: %v", err)
+		}
+	}
+	if s.Journal != nil {
+		if err := s.Journal.Close(); err != nil {
+			log.Printf("close journal: %v", err)
+		}
+	}
 }
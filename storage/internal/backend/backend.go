@@ -0,0 +1,247 @@
+// Package backend describes the storage used by the storage service.
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// TempPrefix starts names reserved for temporary entries of backends, like
+// unfinished uploads. Paths with such names are invalid.
+const TempPrefix = ".ns-upload-"
+
+var (
+	// path is malformed, e.g. contains ".." or NUL
+	ErrInvalidPath = errors.New("invalid path")
+	// path leads out of the backend root, e.g. through a symlink
+	ErrOutsideRoot = errors.New("path is outside of root")
+)
+
+type File struct {
+	Name string
+	Path string
+}
+
+type Directory struct {
+	Name string
+	Path string
+}
+
+// Backend stores files and directories. Paths are slash separated and
+// relative to the backend root, "/" is the root itself.
+type Backend interface {
+	ReadDir(path string) ([]File, []Directory, error)
+	Mkdir(path string) error
+	Open(path string) (io.ReadCloser, error)
+	// Create starts writing file, it replaces existing file on Commit
+	Create(path string) (Writer, error)
+	// Remove deletes file or empty directory
+	Remove(path string) error
+	// Stat returns info about path, exist is false if path not found
+	Stat(path string) (info fs.FileInfo, exist bool, err error)
+	// Rename moves file or directory. Existing file at newpath is replaced
+	// only by file, any other existing newpath is fs.ErrExist.
+	Rename(oldpath, newpath string) error
+}
+
+// Split validates path and splits it into names, root is empty list.
+// Op is used in returned *fs.PathError.
+func Split(op, path string) ([]string, error) {
+	if strings.ContainsRune(path, 0) {
+		return nil, &fs.PathError{Op: op, Path: path, Err: ErrInvalidPath}
+	}
+	names := make([]string, 0)
+	for _, name := range strings.Split(path, "/") {
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			return nil, &fs.PathError{Op: op, Path: path, Err: ErrInvalidPath}
+		}
+		if strings.HasPrefix(name, TempPrefix) {
+			return nil, &fs.PathError{Op: op, Path: path, Err: ErrInvalidPath}
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// SplitRename validates paths of rename and splits them into names. Root
+// can't be moved or replaced and directory can't be moved into itself.
+func SplitRename(oldpath, newpath string) ([]string, []string, error) {
+	invalid := &fs.PathError{Op: "rename", Path: oldpath, Err: ErrInvalidPath}
+	oldNames, err := Split("rename", oldpath)
+	if err != nil {
+		return nil, nil, err
+	}
+	newNames, err := Split("rename", newpath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(oldNames) == 0 || len(newNames) == 0 {
+		return nil, nil, invalid
+	}
+	if len(newNames) > len(oldNames) && strings.Join(newNames[:len(oldNames)], "/") == strings.Join(oldNames, "/") {
+		return nil, nil, invalid
+	}
+	return oldNames, newNames, nil
+}
+
+// Writer is file being written. Content appears at path atomically on
+// Commit, until then readers see previous file or nothing.
+type Writer interface {
+	io.Writer
+	Commit() error
+	// Abort drops written content, it does nothing after Commit
+	Abort() error
+}
+
+// Copier is implemented by backends that copy files and trees without
+// streaming them through the server.
+type Copier interface {
+	// Copy copies file or directory tree, newpath is treated as in Rename.
+	// Progress is called after every copied file, its error stops copying.
+	Copy(oldpath, newpath string, progress func(size int64) error) error
+}
+
+// Digester is implemented by backends that store digests of file content.
+// Stored digests are dropped when content of file is replaced.
+type Digester interface {
+	// SetDigest stores digest of file, algorithm is name like "sha256" or
+	// "mime" for detected MIME type. Digest can contain spaces.
+	SetDigest(path, algorithm, digest string) error
+	// Digest returns stored digest, empty if it isn't stored
+	Digest(path, algorithm string) (string, error)
+}
+
+// RangeOpener is implemented by backends that read part of file without
+// reading data before it.
+type RangeOpener interface {
+	// OpenRange opens length bytes of file starting at offset, length 0
+	// reads until end of file. Range past end of file is empty.
+	OpenRange(path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Lstater is implemented by backends with symbolic links.
+type Lstater interface {
+	// Lstat is Stat that doesn't follow symlink at path
+	Lstat(path string) (info fs.FileInfo, exist bool, err error)
+}
+
+// ChangeOp is kind of Change
+type ChangeOp int
+
+const (
+	OpCreate ChangeOp = iota
+	OpRemove
+	OpMove
+	OpModify
+	// changes under Path were lost, e.g. on overflow of kernel queue
+	OpLost
+)
+
+// Change of file or directory at Path, OldPath is source of OpMove
+type Change struct {
+	Op      ChangeOp
+	Path    string
+	OldPath string
+	Dir     bool
+}
+
+// Watcher is implemented by backends that notice changes made by anyone,
+// not only through the backend.
+type Watcher interface {
+	// Watch calls notify with changes until ctx is done
+	Watch(ctx context.Context, notify func(Change)) error
+}
+
+// Sweeper is implemented by backends that can leave garbage of unfinished
+// writes after crash, Sweep must be called before backend is used.
+type Sweeper interface {
+	Sweep() error
+}
+
+// OpenRange opens part of file like RangeOpener, backends without it seek
+// to offset or skip data before it.
+func OpenRange(b Backend, path string, offset, length int64) (io.ReadCloser, error) {
+	if opener, ok := b.(RangeOpener); ok {
+		return opener.OpenRange(path, offset, length)
+	}
+	file, err := b.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, offset)
+	}
+	if err != nil && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+	if length == 0 {
+		return file, nil
+	}
+	return readCloser{io.LimitReader(file, length), file}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func IsDirExist(b Backend, path string) (bool, error) {
+	info, exist, err := b.Stat(path)
+	if err != nil || !exist {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+func IsFileExist(b Backend, path string) (bool, error) {
+	info, exist, err := b.Stat(path)
+	if err != nil || !exist {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+func IsExist(b Backend, path string) (bool, error) {
+	_, exist, err := b.Stat(path)
+	return exist, err
+}
+
+// NewFileInfo returns fs.FileInfo for backends without one of their own
+func NewFileInfo(name string, size int64, dir bool, modTime time.Time) fs.FileInfo {
+	return &fileInfo{
+		name:    name,
+		size:    size,
+		dir:     dir,
+		modTime: modTime,
+	}
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0770
+	}
+	return 0660
+}
@@ -0,0 +1,516 @@
+// Package backendtest checks that a backend.Backend behaves like local disk.
+package backendtest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"syscall"
+	"testing"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+// Run runs conformance tests, newBackend must return new empty backend for every call.
+func Run(t *testing.T, newBackend func(t *testing.T) backend.Backend) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, b backend.Backend)
+	}{
+		{"ReadDir", testReadDir},
+		{"Mkdir", testMkdir},
+		{"CreateOpen", testCreateOpen},
+		{"CreateTruncate", testCreateTruncate},
+		{"CreateAtomic", testCreateAtomic},
+		{"CreateAbort", testCreateAbort},
+		{"Stat", testStat},
+		{"Remove", testRemove},
+		{"Rename", testRename},
+		{"Copy", testCopy},
+		{"Digest", testDigest},
+		{"OpenRange", testOpenRange},
+		{"InvalidPath", testInvalidPath},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			test.test(t, newBackend(t))
+		})
+	}
+}
+
+func mkdir(t *testing.T, b backend.Backend, path string) {
+	t.Helper()
+	if err := b.Mkdir(path); err != nil {
+		t.Fatalf("Mkdir(%q) err = %v", path, err)
+	}
+}
+
+func write(t *testing.T, b backend.Backend, path string, data []byte) {
+	t.Helper()
+	file, err := b.Create(path)
+	if err != nil {
+		t.Fatalf("Create(%q) err = %v", path, err)
+	}
+	// write in small chunks, like a stream
+	for len(data) > 0 {
+		n := 3
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := file.Write(data[:n]); err != nil {
+			t.Fatalf("Write(%q) err = %v", path, err)
+		}
+		data = data[n:]
+	}
+	if err := file.Commit(); err != nil {
+		t.Fatalf("Commit(%q) err = %v", path, err)
+	}
+}
+
+func read(t *testing.T, b backend.Backend, path string) []byte {
+	t.Helper()
+	file, err := b.Open(path)
+	if err != nil {
+		t.Fatalf("Open(%q) err = %v", path, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("ReadAll(%q) err = %v", path, err)
+	}
+	return data
+}
+
+func testReadDir(t *testing.T, b backend.Backend) {
+	mkdir(t, b, "/dir2")
+	mkdir(t, b, "/dir1")
+	mkdir(t, b, "/dir1/sub")
+	write(t, b, "/file2.txt", []byte("file2"))
+	write(t, b, "/file1.txt", []byte("file1"))
+	write(t, b, "/dir1/file3.txt", []byte("file3"))
+
+	files, dirs, err := b.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []backend.File{{Name: "file1.txt", Path: "/file1.txt"}, {Name: "file2.txt", Path: "/file2.txt"}}
+	wantDirs := []backend.Directory{{Name: "dir1", Path: "/dir1"}, {Name: "dir2", Path: "/dir2"}}
+	if len(files) != len(wantFiles) || len(dirs) != len(wantDirs) {
+		t.Fatalf("ReadDir = %v %v, want %v %v", files, dirs, wantFiles, wantDirs)
+	}
+	for i := range files {
+		if files[i] != wantFiles[i] {
+			t.Errorf("file = %v, want %v", files[i], wantFiles[i])
+		}
+	}
+	for i := range dirs {
+		if dirs[i] != wantDirs[i] {
+			t.Errorf("dir = %v, want %v", dirs[i], wantDirs[i])
+		}
+	}
+
+	files, dirs, err = b.ReadDir("dir1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "dir1/file3.txt" || len(dirs) != 1 || dirs[0].Path != "dir1/sub" {
+		t.Errorf("ReadDir(dir1) = %v %v", files, dirs)
+	}
+
+	if _, _, err := b.ReadDir("/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDir(missing) err = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, _, err := b.ReadDir("/file1.txt"); err == nil {
+		t.Errorf("ReadDir(file) err = nil")
+	}
+}
+
+func testMkdir(t *testing.T, b backend.Backend) {
+	mkdir(t, b, "/dir")
+	mkdir(t, b, "/dir/sub")
+
+	if err := b.Mkdir("/dir"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Mkdir(existing) err = %v, want %v", err, fs.ErrExist)
+	}
+	if err := b.Mkdir("/missing/sub"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Mkdir(missing parent) err = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	ok, err := backend.IsDirExist(b, "/dir/sub")
+	if err != nil || !ok {
+		t.Errorf("IsDirExist = %v, %v, want true", ok, err)
+	}
+}
+
+func testCreateOpen(t *testing.T, b backend.Backend) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	mkdir(t, b, "/dir")
+	write(t, b, "/dir/file.bin", data)
+
+	if got := read(t, b, "/dir/file.bin"); !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes, want %d", len(got), len(data))
+	}
+	if _, err := b.Create("/missing/file.bin"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Create(missing parent) err = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := b.Open("/dir/missing.bin"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(missing) err = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func testCreateTruncate(t *testing.T, b backend.Backend) {
+	write(t, b, "/file.txt", []byte("long content"))
+	write(t, b, "/file.txt", []byte("short"))
+
+	if got := read(t, b, "/file.txt"); string(got) != "short" {
+		t.Errorf("read %q, want %q", got, "short")
+	}
+}
+
+func testCreateAtomic(t *testing.T, b backend.Backend) {
+	mkdir(t, b, "/dir")
+	write(t, b, "/dir/old.txt", []byte("old"))
+
+	// overwrite existing file, then create new one
+	for _, test := range []struct{ path, want string }{{"/dir/old.txt", "old"}, {"/dir/new.txt", ""}} {
+		path, want := test.path, test.want
+		file, err := b.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte("new")); err != nil {
+			t.Fatal(err)
+		}
+
+		// nothing changes before Commit
+		if want == "" {
+			if ok, _ := backend.IsExist(b, path); ok {
+				t.Errorf("%q exist before Commit", path)
+			}
+		} else if got := read(t, b, path); string(got) != want {
+			t.Errorf("read %q before Commit, want %q", got, want)
+		}
+		files, _, err := b.ReadDir("/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 || files[0].Name != "old.txt" {
+			t.Errorf("ReadDir before Commit = %v", files)
+		}
+
+		if err := file.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, b, path); string(got) != "new" {
+			t.Errorf("read %q after Commit, want %q", got, "new")
+		}
+		if err := file.Abort(); err != nil {
+			t.Errorf("Abort after Commit err = %v", err)
+		}
+	}
+}
+
+func testCreateAbort(t *testing.T, b backend.Backend) {
+	write(t, b, "/old.txt", []byte("old"))
+
+	for _, path := range []string{"/new.txt", "/old.txt"} {
+		file, err := b.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Abort(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ok, _ := backend.IsExist(b, "/new.txt"); ok {
+		t.Errorf("aborted file exist")
+	}
+	if got := read(t, b, "/old.txt"); string(got) != "old" {
+		t.Errorf("read %q after Abort, want %q", got, "old")
+	}
+	files, dirs, err := b.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || len(dirs) != 0 {
+		t.Errorf("ReadDir after Abort = %v %v", files, dirs)
+	}
+}
+
+func testStat(t *testing.T, b backend.Backend) {
+	mkdir(t, b, "/dir")
+	write(t, b, "/dir/file.txt", []byte("12345"))
+
+	info, exist, err := b.Stat("/dir/file.txt")
+	if err != nil || !exist {
+		t.Fatalf("Stat(file) = %v, %v", exist, err)
+	}
+	if info.Name() != "file.txt" || info.Size() != 5 || info.IsDir() || info.ModTime().IsZero() {
+		t.Errorf("Stat(file) name=%v size=%v dir=%v mtime=%v", info.Name(), info.Size(), info.IsDir(), info.ModTime())
+	}
+
+	info, exist, err = b.Stat("/dir")
+	if err != nil || !exist {
+		t.Fatalf("Stat(dir) = %v, %v", exist, err)
+	}
+	if info.Name() != "dir" || !info.IsDir() {
+		t.Errorf("Stat(dir) name=%v dir=%v", info.Name(), info.IsDir())
+	}
+
+	_, exist, err = b.Stat("/dir/missing.txt")
+	if err != nil || exist {
+		t.Errorf("Stat(missing) = %v, %v, want false, nil", exist, err)
+	}
+
+	ok, err := backend.IsFileExist(b, "/dir")
+	if err != nil || ok {
+		t.Errorf("IsFileExist(dir) = %v, %v, want false", ok, err)
+	}
+}
+
+func testRemove(t *testing.T, b backend.Backend) {
+	mkdir(t, b, "/dir")
+	mkdir(t, b, "/empty")
+	write(t, b, "/dir/file.txt", []byte("data"))
+
+	if err := b.Remove("/dir"); err == nil {
+		t.Errorf("Remove(not empty dir) err = nil")
+	}
+	for _, path := range []string{"/dir/file.txt", "/dir", "/empty"} {
+		if err := b.Remove(path); err != nil {
+			t.Errorf("Remove(%q) err = %v", path, err)
+		}
+		if ok, _ := backend.IsExist(b, path); ok {
+			t.Errorf("%q exist after Remove", path)
+		}
+	}
+	if err := b.Remove("/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Remove(missing) err = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func testRename(t *testing.T, b backend.Backend) {
+	mkdir(t, b, "/dir")
+	mkdir(t, b, "/dir/sub")
+	mkdir(t, b, "/empty")
+	write(t, b, "/dir/sub/file.txt", []byte("file"))
+	write(t, b, "/a.txt", []byte("a"))
+	write(t, b, "/b.txt", []byte("b"))
+
+	// file, replacing existing file
+	if err := b.Rename("/a.txt", "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, b, "/b.txt"); string(got) != "a" {
+		t.Errorf("read %q after Rename, want %q", got, "a")
+	}
+	if ok, _ := backend.IsExist(b, "/a.txt"); ok {
+		t.Errorf("old file exist after Rename")
+	}
+	if err := b.Rename("/b.txt", "/b.txt"); err != nil {
+		t.Errorf("Rename(same path) err = %v", err)
+	}
+	if err := b.Rename("/b.txt", "/dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// directory with content
+	if err := b.Rename("/dir", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, b, "/moved/sub/file.txt"); string(got) != "file" {
+		t.Errorf("read %q after Rename, want %q", got, "file")
+	}
+	if got := read(t, b, "/moved/b.txt"); string(got) != "a" {
+		t.Errorf("read %q after Rename, want %q", got, "a")
+	}
+	if ok, _ := backend.IsExist(b, "/dir"); ok {
+		t.Errorf("old directory exist after Rename")
+	}
+
+	for _, test := range []struct{ oldpath, newpath string }{
+		{"/moved", "/empty"},
+		{"/moved/b.txt", "/empty"},
+		{"/empty", "/moved/b.txt"},
+	} {
+		if err := b.Rename(test.oldpath, test.newpath); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("Rename(%q, %q) err = %v, want %v", test.oldpath, test.newpath, err, fs.ErrExist)
+		}
+	}
+	for _, test := range []struct{ oldpath, newpath string }{{"/missing", "/new"}, {"/moved/b.txt", "/missing/b.txt"}} {
+		if err := b.Rename(test.oldpath, test.newpath); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Rename(%q, %q) err = %v, want %v", test.oldpath, test.newpath, err, fs.ErrNotExist)
+		}
+	}
+	for _, test := range []struct{ oldpath, newpath string }{{"/moved", "/moved/sub/moved"}, {"/", "/root"}, {"/empty", "/"}} {
+		if err := b.Rename(test.oldpath, test.newpath); !errors.Is(err, backend.ErrInvalidPath) {
+			t.Errorf("Rename(%q, %q) err = %v, want %v", test.oldpath, test.newpath, err, backend.ErrInvalidPath)
+		}
+	}
+}
+
+func testCopy(t *testing.T, b backend.Backend) {
+	copier, ok := b.(backend.Copier)
+	if !ok {
+		t.Skip("backend is not Copier")
+	}
+	mkdir(t, b, "/dir")
+	mkdir(t, b, "/dir/sub")
+	write(t, b, "/dir/a.txt", []byte("a"))
+	write(t, b, "/dir/sub/b.txt", []byte("bb"))
+
+	var files, size int64
+	progress := func(n int64) error {
+		files++
+		size += n
+		return nil
+	}
+	if err := copier.Copy("/dir", "/copy", progress); err != nil {
+		t.Fatal(err)
+	}
+	if files != 2 || size != 3 {
+		t.Errorf("progress = %v files, %v bytes, want 2, 3", files, size)
+	}
+	for path, want := range map[string]string{"/dir/a.txt": "a", "/copy/a.txt": "a", "/copy/sub/b.txt": "bb"} {
+		if got := read(t, b, path); string(got) != want {
+			t.Errorf("read(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	// file replaces file, directory is never replaced
+	if err := copier.Copy("/dir/sub/b.txt", "/copy/a.txt", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, b, "/copy/a.txt"); string(got) != "bb" {
+		t.Errorf("read %q after Copy, want %q", got, "bb")
+	}
+	if err := copier.Copy("/dir", "/copy", nil); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Copy(over dir) err = %v, want %v", err, fs.ErrExist)
+	}
+	if err := copier.Copy("/missing", "/new", nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Copy(missing) err = %v, want %v", err, fs.ErrNotExist)
+	}
+	if err := copier.Copy("/dir", "/dir/sub/dir", nil); !errors.Is(err, backend.ErrInvalidPath) {
+		t.Errorf("Copy(into itself) err = %v, want %v", err, backend.ErrInvalidPath)
+	}
+
+	// error of progress stops copying
+	stop := errors.New("stop")
+	err := copier.Copy("/dir", "/stopped", func(int64) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("Copy(stopped) err = %v, want %v", err, stop)
+	}
+}
+
+func testDigest(t *testing.T, b backend.Backend) {
+	digester, ok := b.(backend.Digester)
+	if !ok {
+		t.Skip("backend is not Digester")
+	}
+	write(t, b, "/a.txt", []byte("a"))
+	if digest, err := digester.Digest("/a.txt", "sha256"); err != nil || digest != "" {
+		t.Errorf("Digest(not set) = %q, %v, want empty", digest, err)
+	}
+	if err := digester.SetDigest("/a.txt", "sha256", "aaaa"); errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("digests are not supported")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if digest, err := digester.Digest("/a.txt", "sha256"); err != nil || digest != "aaaa" {
+		t.Errorf("Digest = %q, %v, want %q", digest, err, "aaaa")
+	}
+	if digest, err := digester.Digest("/a.txt", "blake3"); err != nil || digest != "" {
+		t.Errorf("Digest(other algorithm) = %q, %v, want empty", digest, err)
+	}
+	const mimeType = "text/plain; charset=utf-8"
+	if err := digester.SetDigest("/a.txt", "mime", mimeType); err != nil {
+		t.Fatal(err)
+	}
+	if digest, err := digester.Digest("/a.txt", "mime"); err != nil || digest != mimeType {
+		t.Errorf("Digest(with spaces) = %q, %v, want %q", digest, err, mimeType)
+	}
+
+	// digest moves with file and is dropped with its content
+	if err := b.Rename("/a.txt", "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if digest, err := digester.Digest("/b.txt", "sha256"); err != nil || digest != "aaaa" {
+		t.Errorf("Digest after Rename = %q, %v, want %q", digest, err, "aaaa")
+	}
+	write(t, b, "/b.txt", []byte("b"))
+	if digest, err := digester.Digest("/b.txt", "sha256"); err != nil || digest != "" {
+		t.Errorf("Digest after write = %q, %v, want empty", digest, err)
+	}
+
+	if err := digester.SetDigest("/missing", "sha256", "aaaa"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("SetDigest(missing) err = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := digester.Digest("/missing", "sha256"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Digest(missing) err = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func testOpenRange(t *testing.T, b backend.Backend) {
+	write(t, b, "/a.txt", []byte("0123456789"))
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 0, "0123456789"},
+		{3, 0, "3456789"},
+		{3, 4, "3456"},
+		{8, 5, "89"},
+		{10, 0, ""},
+		{20, 1, ""},
+	}
+	for _, test := range tests {
+		file, err := backend.OpenRange(b, "/a.txt", test.offset, test.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(file)
+		file.Close()
+		if err != nil || string(got) != test.want {
+			t.Errorf("OpenRange(%v, %v) = %q, %v, want %q", test.offset, test.length, got, err, test.want)
+		}
+	}
+	if _, err := backend.OpenRange(b, "/missing", 1, 1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenRange(missing) err = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func testInvalidPath(t *testing.T, b backend.Backend) {
+	for _, path := range []string{"..", "../file.txt", "/dir/../../file.txt", "file\x00.txt", "/" + backend.TempPrefix + "x", "/" + backend.TempPrefix + "dir/file.txt"} {
+		if _, _, err := b.ReadDir(path); !errors.Is(err, backend.ErrInvalidPath) {
+			t.Errorf("ReadDir(%q) err = %v", path, err)
+		}
+		if err := b.Mkdir(path); !errors.Is(err, backend.ErrInvalidPath) {
+			t.Errorf("Mkdir(%q) err = %v", path, err)
+		}
+		if _, err := b.Open(path); !errors.Is(err, backend.ErrInvalidPath) {
+			t.Errorf("Open(%q) err = %v", path, err)
+		}
+		if _, err := b.Create(path); !errors.Is(err, backend.ErrInvalidPath) {
+			t.Errorf("Create(%q) err = %v", path, err)
+		}
+		if err := b.Remove(path); !errors.Is(err, backend.ErrInvalidPath) {
+			t.Errorf("Remove(%q) err = %v", path, err)
+		}
+		if _, _, err := b.Stat(path); !errors.Is(err, backend.ErrInvalidPath) {
+			t.Errorf("Stat(%q) err = %v", path, err)
+		}
+		if err := b.Rename(path, "/file.txt"); !errors.Is(err, backend.ErrInvalidPath) {
+			t.Errorf("Rename(%q) err = %v", path, err)
+		}
+	}
+	if err := b.Remove("/"); !errors.Is(err, backend.ErrInvalidPath) {
+		t.Errorf("Remove(root) err = %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+// Package events delivers changes of files to watchers. Changes come from
+// the service itself and from backend watching changes made past it, the
+// latter are dropped when they are echo of change the service expects.
+package events
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+const (
+	// changes not received yet by watcher, it's closed when they overflow
+	bufferSize = 256
+	// how long after service change backend may notice its echo
+	echoWindow = 2 * time.Second
+)
+
+type Hub struct {
+	mu       sync.Mutex
+	watchers map[*Watcher]struct{}
+	// path changed by service -> its echoes
+	echoes map[string]*echo
+	// tree changed by service as whole -> its echoes
+	treeEchoes map[string]*echo
+	swept      time.Time
+	closed     bool
+}
+
+// echoes of service changes of path or tree
+type echo struct {
+	// changes still running
+	pending int
+	// echoes not noticed yet, tree has any number of them
+	count int
+	// end of last change
+	done time.Time
+}
+
+// active reports whether backend may still notice echo
+func (e *echo) active(now time.Time) bool {
+	return e.pending > 0 || now.Sub(e.done) <= echoWindow
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		watchers:   make(map[*Watcher]struct{}),
+		echoes:     make(map[string]*echo),
+		treeEchoes: make(map[string]*echo),
+	}
+}
+
+// Watcher receives changes of path, or of entries under it if recursive
+type Watcher struct {
+	// closed by Close or when watcher is too slow
+	Events <-chan backend.Change
+
+	hub       *Hub
+	events    chan backend.Change
+	path      string
+	recursive bool
+	lost      bool
+}
+
+// Watch starts watching path, watcher must be closed
+func (h *Hub) Watch(path string, recursive bool) *Watcher {
+	events := make(chan backend.Change, bufferSize)
+	w := &Watcher{
+		Events:    events,
+		hub:       h,
+		events:    events,
+		path:      clean(path),
+		recursive: recursive,
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(events)
+		return w
+	}
+	h.watchers[w] = struct{}{}
+	return w
+}
+
+// Close stops all watchers and watchers started later, watchers aren't
+// lost. Nil hub does nothing.
+func (h *Hub) Close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for w := range h.watchers {
+		w.stop()
+	}
+}
+
+// Close stops watching, it can be called more than once
+func (w *Watcher) Close() {
+	w.hub.mu.Lock()
+	defer w.hub.mu.Unlock()
+	w.stop()
+}
+
+// Lost reports that watcher was closed because changes overflowed
+func (w *Watcher) Lost() bool {
+	w.hub.mu.Lock()
+	defer w.hub.mu.Unlock()
+	return w.lost
+}
+
+// caller must hold hub.mu
+func (w *Watcher) stop() {
+	if _, ok := w.hub.watchers[w]; ok {
+		delete(w.hub.watchers, w)
+		close(w.events)
+	}
+}
+
+// matches reports whether change of p is visible to watcher
+func (w *Watcher) matches(p string) bool {
+	if p == "" {
+		return false
+	}
+	if p == w.path {
+		return true
+	}
+	if !w.recursive {
+		return path.Dir(p) == w.path
+	}
+	return w.path == "/" || strings.HasPrefix(p, w.path+"/")
+}
+
+// Expect tells hub that service is about to change paths, each change of
+// them noticed by backend is dropped as echo once. Returned func ends the
+// change, echoes are expected for echoWindow after it. Nil hub does nothing.
+func (h *Hub) Expect(paths ...string) func() {
+	return h.expect(h.echoesOf(false), paths)
+}
+
+// ExpectTree is Expect of change of whole tree at p, like removal or copy of
+// directory, all changes under it are dropped until echoWindow after end.
+func (h *Hub) ExpectTree(p string) func() {
+	return h.expect(h.echoesOf(true), []string{p})
+}
+
+func (h *Hub) echoesOf(tree bool) map[string]*echo {
+	if h == nil {
+		return nil
+	}
+	if tree {
+		return h.treeEchoes
+	}
+	return h.echoes
+}
+
+func (h *Hub) expect(echoes map[string]*echo, paths []string) func() {
+	if h == nil {
+		return func() {}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sweep(time.Now())
+	expected := make([]*echo, len(paths))
+	for i, p := range paths {
+		p = clean(p)
+		e, ok := echoes[p]
+		if !ok {
+			e = &echo{}
+			echoes[p] = e
+		}
+		e.pending++
+		e.count++
+		expected[i] = e
+	}
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for _, e := range expected {
+			e.pending--
+			e.done = time.Now()
+		}
+	}
+}
+
+// drop echoes past their window, caller must hold h.mu
+func (h *Hub) sweep(now time.Time) {
+	if now.Sub(h.swept) <= echoWindow {
+		return
+	}
+	for _, echoes := range []map[string]*echo{h.echoes, h.treeEchoes} {
+		for p, e := range echoes {
+			if !e.active(now) {
+				delete(echoes, p)
+			}
+		}
+	}
+	h.swept = now
+}
+
+// Overflow closes all watchers as lost, for changes lost before they
+// reached hub. Nil hub does nothing.
+func (h *Hub) Overflow() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for w := range h.watchers {
+		w.lost = true
+		w.stop()
+	}
+}
+
+// Publish sends change made by service to watchers. Nil hub does nothing.
+func (h *Hub) Publish(change backend.Change) {
+	if h == nil {
+		return
+	}
+	change.Path = clean(change.Path)
+	if change.OldPath != "" {
+		change.OldPath = clean(change.OldPath)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.send(change)
+}
+
+// PublishExternal sends change noticed by backend, unless it is expected
+// echo, and reports whether it was sent. Nil hub does nothing.
+func (h *Hub) PublishExternal(change backend.Change) bool {
+	if h == nil {
+		return false
+	}
+	change.Path = clean(change.Path)
+	if change.OldPath != "" {
+		change.OldPath = clean(change.OldPath)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if h.echo(change.Path, now) && (change.OldPath == "" || h.echo(change.OldPath, now)) {
+		h.consume(change.Path)
+		if change.OldPath != "" {
+			h.consume(change.OldPath)
+		}
+		return false
+	}
+	h.send(change)
+	return true
+}
+
+// echo reports whether change of p is expected echo, caller must hold h.mu
+func (h *Hub) echo(p string, now time.Time) bool {
+	if e, ok := h.echoes[p]; ok && e.count > 0 && e.active(now) {
+		return true
+	}
+	for {
+		if e, ok := h.treeEchoes[p]; ok && e.active(now) {
+			return true
+		}
+		if p == "/" {
+			return false
+		}
+		p = path.Dir(p)
+	}
+}
+
+// count noticed echo of p, echo of tree isn't counted. Caller must hold h.mu.
+func (h *Hub) consume(p string) {
+	if e, ok := h.echoes[p]; ok && e.count > 0 {
+		e.count--
+		if e.count == 0 && e.pending == 0 {
+			delete(h.echoes, p)
+		}
+	}
+}
+
+// caller must hold h.mu
+func (h *Hub) send(change backend.Change) {
+	for w := range h.watchers {
+		if !w.matches(change.Path) && !w.matches(change.OldPath) {
+			continue
+		}
+		select {
+		case w.events <- change:
+		default:
+			w.lost = true
+			w.stop()
+		}
+	}
+}
+
+func clean(p string) string {
+	return path.Join("/", p)
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+// receive changes already sent to watcher
+func received(w *Watcher) []backend.Change {
+	changes := make([]backend.Change, 0)
+	for {
+		select {
+		case change, ok := <-w.Events:
+			if !ok {
+				return changes
+			}
+			changes = append(changes, change)
+		default:
+			return changes
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	h := NewHub()
+	dir := h.Watch("/dir", false)
+	defer dir.Close()
+	tree := h.Watch("dir/", true)
+	defer tree.Close()
+
+	h.Publish(backend.Change{Op: backend.OpCreate, Path: "/dir/a"})
+	h.Publish(backend.Change{Op: backend.OpCreate, Path: "/dir/sub/b"})
+	h.Publish(backend.Change{Op: backend.OpCreate, Path: "/dirty"})
+	h.Publish(backend.Change{Op: backend.OpMove, Path: "/other", OldPath: "/dir/a"})
+
+	changes := received(dir)
+	if len(changes) != 2 || changes[0].Path != "/dir/a" || changes[1].OldPath != "/dir/a" {
+		t.Errorf("watcher of dir received %v", changes)
+	}
+	changes = received(tree)
+	if len(changes) != 3 || changes[1].Path != "/dir/sub/b" {
+		t.Errorf("recursive watcher received %v", changes)
+	}
+}
+
+func TestPublishExternal(t *testing.T) {
+	h := NewHub()
+	w := h.Watch("/", true)
+	defer w.Close()
+
+	removeDone := h.ExpectTree("/dir")
+	mkdirDone := h.Expect("/new")
+	// echo noticed before service published change
+	h.PublishExternal(backend.Change{Op: backend.OpCreate, Path: "/new", Dir: true})
+	h.Publish(backend.Change{Op: backend.OpCreate, Path: "/new", Dir: true})
+	mkdirDone()
+	h.Publish(backend.Change{Op: backend.OpRemove, Path: "/dir", Dir: true})
+	removeDone()
+	// echoes of removal of dir content
+	h.PublishExternal(backend.Change{Op: backend.OpRemove, Path: "/dir/file"})
+	h.PublishExternal(backend.Change{Op: backend.OpRemove, Path: "/dir/sub/file"})
+	// echo of mkdir is already noticed, these are made past service
+	h.PublishExternal(backend.Change{Op: backend.OpCreate, Path: "/new/file"})
+	h.PublishExternal(backend.Change{Op: backend.OpMove, Path: "/other", OldPath: "/new"})
+
+	changes := received(w)
+	if len(changes) != 4 || changes[2].Path != "/new/file" || changes[3].OldPath != "/new" {
+		t.Errorf("received %v", changes)
+	}
+
+	// echo of move is noticed once
+	moveDone := h.Expect("/a", "/b")
+	h.Publish(backend.Change{Op: backend.OpMove, Path: "/b", OldPath: "/a"})
+	moveDone()
+	if h.PublishExternal(backend.Change{Op: backend.OpMove, Path: "/b", OldPath: "/a"}) {
+		t.Error("echo of move is sent")
+	}
+	if !h.PublishExternal(backend.Change{Op: backend.OpModify, Path: "/b"}) {
+		t.Error("change after echo of move is dropped")
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	h := NewHub()
+	w := h.Watch("/", true)
+	for i := 0; i <= bufferSize; i++ {
+		h.Publish(backend.Change{Op: backend.OpModify, Path: "/file"})
+	}
+	if !w.Lost() {
+		t.Error("overflowed watcher isn't lost")
+	}
+	if n := len(received(w)); n != bufferSize {
+		t.Errorf("received %v changes, expected %v", n, bufferSize)
+	}
+	w.Close()
+
+	var nilHub *Hub
+	nilHub.Expect("/file")()
+	nilHub.Publish(backend.Change{Path: "/file"})
+}
+
+func TestClose(t *testing.T) {
+	h := NewHub()
+	w := h.Watch("/", true)
+	h.Close()
+	if _, ok := <-w.Events; ok || w.Lost() {
+		t.Error("watcher isn't stopped by Close or is lost")
+	}
+	if _, ok := <-h.Watch("/", true).Events; ok {
+		t.Error("watcher started after Close isn't stopped")
+	}
+}
@@ -0,0 +1,108 @@
+package filemanager
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// copy src into temporary directory next to dst and rename copy into place
+func copyAtomic(src, dst string, progress func(size int64) error) error {
+	tmp, err := os.MkdirTemp(filepath.Dir(dst), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	staged := filepath.Join(tmp, filepath.Base(dst))
+	if err := copyTree(src, staged, progress); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, dst); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dst))
+}
+
+// move src to dst on other filesystem
+func moveAcross(src, dst string) error {
+	if err := copyAtomic(src, dst, nil); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copy file, symlink or directory tree, keeping permissions and modification times
+func copyTree(src, dst string, progress func(size int64) error) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case info.IsDir():
+		if err := os.Mkdir(dst, 0700); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := copyTree(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), progress); err != nil {
+				return err
+			}
+		}
+	case info.Mode().IsRegular():
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+		if progress != nil {
+			if err := progress(info.Size()); err != nil {
+				return err
+			}
+		}
+	default:
+		// devices, sockets and pipes
+		return &fs.PathError{Op: "copy", Path: src, Err: syscall.ENOTSUP}
+	}
+
+	// umask could limit permissions on create
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	// share blocks if possible, otherwise io.Copy uses copy_file_range
+	err = reflink(out, in)
+	if err != nil {
+		_, err = io.Copy(out, in)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package filemanager
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// prefix of extended attributes with digests
+const digestXattr = "user.ns."
+
+// SetDigest stores digest in extended attribute of file together with its
+// modification time and size, so digest of file changed in place is ignored.
+func (fm *FileManager) SetDigest(path, algorithm, digest string) error {
+	full, err := fm.Resolve(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &fs.PathError{Op: "setdigest", Path: path, Err: syscall.EISDIR}
+	}
+	value := fmt.Sprintf("%d %d %s", info.ModTime().UnixNano(), info.Size(), digest)
+	if err := setxattr(full, digestXattr+algorithm, []byte(value)); err != nil {
+		return &fs.PathError{Op: "setdigest", Path: path, Err: err}
+	}
+	return nil
+}
+
+// Digest returns empty digest if file system doesn't support extended
+// attributes.
+func (fm *FileManager) Digest(path, algorithm string) (string, error) {
+	full, err := fm.Resolve(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return "", err
+	}
+	value, err := getxattr(full, digestXattr+algorithm)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return "", nil
+	}
+	if err != nil || value == nil {
+		return "", err
+	}
+
+	// digest is rest of value, it can contain spaces
+	fields := strings.SplitN(string(value), " ", 3)
+	if len(fields) != 3 {
+		return "", nil
+	}
+	if fields[0] != strconv.FormatInt(info.ModTime().UnixNano(), 10) || fields[1] != strconv.FormatInt(info.Size(), 10) {
+		return "", nil
+	}
+	return fields[2], nil
+}
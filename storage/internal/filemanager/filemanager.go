@@ -1,42 +1,60 @@
+// Package filemanager implements backend.Backend on top of local disk.
 package filemanager
 
 import (
 	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
 )
 
-type File struct {
-	Name string
-	Path string
-}
+const (
+	// max symlinks followed while resolving one path
+	maxSymlinks = 255
+	// prefix of temporary entries of unfinished uploads and copies, hidden from ReadDir
+	tempPrefix = backend.TempPrefix
+)
 
-type Directory struct {
-	Name string
-	Path string
-}
+var (
+	_ backend.Backend  = (*FileManager)(nil)
+	_ backend.Sweeper  = (*FileManager)(nil)
+	_ backend.Copier   = (*FileManager)(nil)
+	_ backend.Lstater  = (*FileManager)(nil)
+	_ backend.Digester = (*FileManager)(nil)
+)
 
 type FileManager struct {
 	Root string
 }
 
-func (fm *FileManager) ReadDir(path string) ([]File, []Directory, error) {
-	entriesList, err := os.ReadDir(fm.Full(path))
+func (fm *FileManager) ReadDir(path string) ([]backend.File, []backend.Directory, error) {
+	full, err := fm.Resolve(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	entriesList, err := os.ReadDir(full)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	filesList := make([]File, 0)
-	dirsList := make([]Directory, 0)
+	filesList := make([]backend.File, 0)
+	dirsList := make([]backend.Directory, 0)
 	for _, entry := range entriesList {
+		if strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue
+		}
 		if entry.IsDir() {
-			dirsList = append(dirsList, Directory{
+			dirsList = append(dirsList, backend.Directory{
 				Name: entry.Name(),
 				Path: filepath.Join(path, entry.Name()),
 			})
 		} else {
-			filesList = append(filesList, File{
+			filesList = append(filesList, backend.File{
 				Name: entry.Name(),
 				Path: filepath.Join(path, entry.Name()),
 			})
@@ -46,68 +64,292 @@ func (fm *FileManager) ReadDir(path string) ([]File, []Directory, error) {
 }
 
 func (fm *FileManager) Mkdir(path string) error {
-	return os.Mkdir(fm.Full(path), 0770)
+	full, err := fm.Resolve(path)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(full, 0770)
+}
+
+func (fm *FileManager) Open(path string) (io.ReadCloser, error) {
+	full, err := fm.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(full)
 }
 
-func (fm *FileManager) Open(path string) (*os.File, error) {
-	return os.Open(fm.Full(path))
+// Create writes into hidden temporary file in the same directory, Commit
+// syncs it and renames over path.
+func (fm *FileManager) Create(path string) (backend.Writer, error) {
+	full, err := fm.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(full)
+	if err == nil && info.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: path, Err: syscall.EISDIR}
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(full), tempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(0660); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &writer{file: file, path: full}, nil
 }
 
-func (fm *FileManager) Create(path string) (*os.File, error) {
-	return os.Create(fm.Full(path))
+// Sweep removes temporary entries left by uploads and copies interrupted by crash
+func (fm *FileManager) Sweep() error {
+	root, err := filepath.EvalSymlinks(fm.Root)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(entry.Name(), tempPrefix) {
+			return nil
+		}
+		if entry.IsDir() {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		return os.Remove(path)
+	})
 }
 
+// Remove deletes file or empty directory. Symlink itself is removed, not its target.
 func (fm *FileManager) Remove(path string) error {
-	return os.Remove(fm.Full(path))
+	full, err := fm.resolveParent("remove", path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(full)
 }
 
-// return full path
-func (fm *FileManager) Full(path string) string {
-	return filepath.Join(fm.Root, path)
+// Rename moves file or directory, symlink is moved itself. Between
+// filesystems, e.g. mount points inside Root, entry is copied and removed.
+func (fm *FileManager) Rename(oldpath, newpath string) error {
+	oldFull, newFull, same, err := fm.resolveRename("rename", oldpath, newpath)
+	if err != nil || same {
+		return err
+	}
+	err = os.Rename(oldFull, newFull)
+	if errors.Is(err, syscall.EXDEV) {
+		return moveAcross(oldFull, newFull)
+	}
+	if err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(oldFull)); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(newFull))
+}
+
+// Copy copies file or directory tree keeping permissions and modification
+// times, symlinks are copied as symlinks. File data is cloned with reflink
+// where filesystem supports it. Copy appears at newpath only when complete.
+func (fm *FileManager) Copy(oldpath, newpath string, progress func(size int64) error) error {
+	oldFull, newFull, same, err := fm.resolveRename("copy", oldpath, newpath)
+	if err != nil || same {
+		return err
+	}
+	return copyAtomic(oldFull, newFull, progress)
 }
 
+// resolve and check paths of Rename and Copy, same is true when both lead
+// to the same entry
+func (fm *FileManager) resolveRename(op, oldpath, newpath string) (oldFull, newFull string, same bool, err error) {
+	if _, _, err := backend.SplitRename(oldpath, newpath); err != nil {
+		return "", "", false, err
+	}
+	oldFull, err = fm.resolveParent(op, oldpath)
+	if err != nil {
+		return "", "", false, err
+	}
+	newFull, err = fm.resolveParent(op, newpath)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	oldInfo, err := os.Lstat(oldFull)
+	if err != nil {
+		return "", "", false, err
+	}
+	newInfo, err := os.Lstat(newFull)
+	if err == nil {
+		if os.SameFile(oldInfo, newInfo) {
+			return oldFull, newFull, true, nil
+		}
+		// os.Rename would replace empty directory
+		if oldInfo.IsDir() || newInfo.IsDir() {
+			return "", "", false, &fs.PathError{Op: op, Path: newpath, Err: fs.ErrExist}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", "", false, err
+	}
+	return oldFull, newFull, false, nil
+}
+
+// resolve parent directory of path and join last name, so that symlink at
+// path is addressed itself, not its target
+func (fm *FileManager) resolveParent(op, path string) (string, error) {
+	dir, name := filepath.Split(strings.TrimRight(path, "/"))
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, 0) || strings.HasPrefix(name, tempPrefix) {
+		return "", &fs.PathError{Op: op, Path: path, Err: backend.ErrInvalidPath}
+	}
+	full, err := fm.Resolve(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(full, name), nil
+}
+
+// Resolve returns the full path of path inside Root.
+// Path is always relative to Root ("/" is Root itself), must not contain ".."
+// and must not lead out of Root through symlinks.
+func (fm *FileManager) Resolve(path string) (string, error) {
+	rest, err := backend.Split("resolve", path)
+	if err != nil {
+		return "", err
+	}
+
+	root, err := filepath.EvalSymlinks(fm.Root)
+	if err != nil {
+		return "", err
+	}
+
+	// walk components one by one and follow symlinks by hand, so that a link
+	// can't point outside of root even if its target doesn't exist yet
+	current := root
+	links := 0
+	for len(rest) > 0 {
+		next := filepath.Join(current, rest[0])
+		info, err := os.Lstat(next)
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.Join(append([]string{current}, rest...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			current = next
+			rest = rest[1:]
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &fs.PathError{Op: "resolve", Path: path, Err: backend.ErrInvalidPath}
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(current, target)
+		}
+		rel, err := filepath.Rel(root, filepath.Clean(target))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", &fs.PathError{Op: "resolve", Path: path, Err: backend.ErrOutsideRoot}
+		}
+
+		// continue from root with components of link target
+		current = root
+		targetRest := make([]string, 0)
+		if rel != "." {
+			targetRest = strings.Split(rel, "/")
+		}
+		rest = append(targetRest, rest[1:]...)
+	}
+	return current, nil
+}
 
 func (fm *FileManager) Stat(path string) (fs.FileInfo, bool, error) {
-    info, err := os.Stat(fm.Full(path))
-    if err == nil {
-        return info, true, nil
-    }
-    if errors.Is(err, os.ErrNotExist){
-        return nil, false, nil
-    } else {
-        return nil, false, err
-    }
-}
-func (fm *FileManager) IsDirExist(path string) (bool, error) {
-	stat, err := os.Stat(fm.Full(path))
-	switch {
-	case err == nil:
-		return stat.IsDir(), nil
-	case errors.Is(err, os.ErrNotExist):
-		return false, nil
-	default:
-		return false, err
-	}
-}
-func (fm *FileManager) IsFileExist(path string) (bool, error) {
-	stat, err := os.Stat(fm.Full(path))
-	switch {
-	case err == nil:
-		return !stat.IsDir(), nil
-	case errors.Is(err, os.ErrNotExist):
-		return false, nil
-	default:
-		return false, err
-	}
-}
-func (fm *FileManager) IsExist(path string) (bool, error) {
-    _, err := os.Stat(fm.Full(path))
-	switch {
-	case err == nil:
-		return true, nil
-	case errors.Is(err, os.ErrNotExist):
-		return false, nil
-	default:
-		return false, err
+	full, err := fm.Resolve(path)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := os.Stat(full)
+	if err == nil {
+		return info, true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	} else {
+		return nil, false, err
+	}
+}
+
+func (fm *FileManager) Lstat(path string) (fs.FileInfo, bool, error) {
+	full, err := fm.resolveParent("lstat", path)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := os.Lstat(full)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return info, true, nil
+}
+
+type writer struct {
+	file *os.File
+	path string
+	done bool
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	return w.file.Write(b)
+}
+
+func (w *writer) Commit() error {
+	if w.done {
+		return fs.ErrClosed
+	}
+	w.done = true
+
+	err := w.file.Sync()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.file.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(w.path))
+}
+
+func (w *writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+// make rename durable
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	defer dir.Close()
+	return dir.Sync()
 }
@@ -0,0 +1,317 @@
+package filemanager
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+	"github.com/muskelo/ns_server/storage/internal/backend/backendtest"
+)
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		return &FileManager{Root: t.TempDir()}
+	})
+}
+
+// create layout:
+//
+//	outside/secret.txt
+//	root/dir/file.txt
+//	root/inner -> dir
+//	root/abs -> <outside>
+//	root/rel -> ../outside
+//	root/chain -> inner/../abs
+//	root/dangling -> <outside>/new.txt
+//	root/loop1 -> loop2, root/loop2 -> loop1
+func newTestFM(t *testing.T) (*FileManager, string) {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+
+	for _, dir := range []string{filepath.Join(root, "dir"), outside} {
+		if err := os.MkdirAll(dir, 0770); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(root, "dir/file.txt"):  "inside",
+		filepath.Join(outside, "secret.txt"): "secret",
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"inner":    "dir",
+		"abs":      outside,
+		"rel":      "../outside",
+		"chain":    "inner/../abs",
+		"dangling": filepath.Join(outside, "new.txt"),
+		"loop1":    "loop2",
+		"loop2":    "loop1",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &FileManager{Root: root}, outside
+}
+
+func TestResolveEscape(t *testing.T) {
+	fm, _ := newTestFM(t)
+
+	tests := []struct {
+		path string
+		want error
+	}{
+		{"..", backend.ErrInvalidPath},
+		{"../outside/secret.txt", backend.ErrInvalidPath},
+		{"/../outside/secret.txt", backend.ErrInvalidPath},
+		{"dir/../../outside/secret.txt", backend.ErrInvalidPath},
+		{"dir/..", backend.ErrInvalidPath},
+		{"dir/file.txt\x00", backend.ErrInvalidPath},
+		{"abs", backend.ErrOutsideRoot},
+		{"abs/secret.txt", backend.ErrOutsideRoot},
+		{"rel/secret.txt", backend.ErrOutsideRoot},
+		{"chain/secret.txt", backend.ErrOutsideRoot},
+		{"dangling", backend.ErrOutsideRoot},
+		{"loop1", backend.ErrInvalidPath},
+	}
+	for _, test := range tests {
+		_, err := fm.Resolve(test.path)
+		if !errors.Is(err, test.want) {
+			t.Errorf("Resolve(%q) err = %v, want %v", test.path, err, test.want)
+		}
+	}
+}
+
+func TestResolveInside(t *testing.T) {
+	fm, _ := newTestFM(t)
+	root, err := filepath.EvalSymlinks(fm.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", root},
+		{"/", root},
+		{"/dir/file.txt", filepath.Join(root, "dir/file.txt")},
+		{"dir/./file.txt", filepath.Join(root, "dir/file.txt")},
+		{"inner/file.txt", filepath.Join(root, "dir/file.txt")},
+		{"dir/missing/file.txt", filepath.Join(root, "dir/missing/file.txt")},
+	}
+	for _, test := range tests {
+		got, err := fm.Resolve(test.path)
+		if err != nil {
+			t.Errorf("Resolve(%q) err = %v", test.path, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Resolve(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestMethodsEscape(t *testing.T) {
+	fm, outside := newTestFM(t)
+
+	if _, _, err := fm.ReadDir("abs"); !errors.Is(err, backend.ErrOutsideRoot) {
+		t.Errorf("ReadDir err = %v, want %v", err, backend.ErrOutsideRoot)
+	}
+	if _, err := fm.Open("rel/secret.txt"); !errors.Is(err, backend.ErrOutsideRoot) {
+		t.Errorf("Open err = %v, want %v", err, backend.ErrOutsideRoot)
+	}
+	if _, _, err := fm.Stat("../outside/secret.txt"); !errors.Is(err, backend.ErrInvalidPath) {
+		t.Errorf("Stat err = %v, want %v", err, backend.ErrInvalidPath)
+	}
+	if err := fm.Mkdir("abs/newdir"); !errors.Is(err, backend.ErrOutsideRoot) {
+		t.Errorf("Mkdir err = %v, want %v", err, backend.ErrOutsideRoot)
+	}
+	if _, err := fm.Create("dangling"); !errors.Is(err, backend.ErrOutsideRoot) {
+		t.Errorf("Create err = %v, want %v", err, backend.ErrOutsideRoot)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file created outside of root, stat err = %v", err)
+	}
+	if err := fm.Remove("abs/secret.txt"); !errors.Is(err, backend.ErrOutsideRoot) {
+		t.Errorf("Remove err = %v, want %v", err, backend.ErrOutsideRoot)
+	}
+	if err := fm.Remove("/"); !errors.Is(err, backend.ErrInvalidPath) {
+		t.Errorf("Remove root err = %v, want %v", err, backend.ErrInvalidPath)
+	}
+}
+
+func TestRemoveSymlink(t *testing.T) {
+	fm, outside := newTestFM(t)
+
+	if err := fm.Remove("abs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(fm.Root, "abs")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("symlink not removed, lstat err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.txt")); err != nil {
+		t.Errorf("symlink target removed, stat err = %v", err)
+	}
+}
+
+func TestRenameSymlink(t *testing.T) {
+	fm, outside := newTestFM(t)
+
+	if err := fm.Rename("abs", "dir/abs"); err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(filepath.Join(fm.Root, "dir/abs"))
+	if err != nil || target != outside {
+		t.Errorf("moved symlink = %q, %v, want %q", target, err, outside)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.txt")); err != nil {
+		t.Errorf("symlink target moved, stat err = %v", err)
+	}
+}
+
+func TestMoveAcross(t *testing.T) {
+	base := t.TempDir()
+	src := filepath.Join(base, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub/file.txt"), []byte("data"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/file.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, path := range []string{"sub/file.txt", "sub", "."} {
+		if err := os.Chtimes(filepath.Join(src, path), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(base, "dst")
+	if err := moveAcross(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(src); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("source not removed, lstat err = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "link"))
+	if err != nil || string(data) != "data" {
+		t.Errorf("read through link = %q, %v, want %q", data, err, "data")
+	}
+	for path, mode := range map[string]os.FileMode{"sub/file.txt": 0640, "sub": 0750} {
+		info, err := os.Stat(filepath.Join(dst, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode || !info.ModTime().Equal(modTime) {
+			t.Errorf("%v mode=%v mtime=%v, want %v %v", path, info.Mode().Perm(), info.ModTime(), mode, modTime)
+		}
+	}
+	entries, err := os.ReadDir(base)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("entries after move = %v, %v, want only dst", entries, err)
+	}
+}
+
+func TestCopyAtomic(t *testing.T) {
+	fm := &FileManager{Root: t.TempDir()}
+	if err := os.MkdirAll(filepath.Join(fm.Root, "dir/sub"), 0770); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dir/a.txt", "dir/sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(fm.Root, name), []byte(name), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(fm.Root, "dir/a.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// stopped after first file, nothing appears
+	stop := errors.New("stop")
+	if err := fm.Copy("dir", "stopped", func(int64) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("Copy err = %v, want %v", err, stop)
+	}
+	entries, err := os.ReadDir(fm.Root)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("entries after stopped Copy = %v, %v, want only dir", entries, err)
+	}
+
+	if err := fm.Copy("dir", "copy", nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(fm.Root, "copy/a.txt"))
+	if err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("copied file mtime = %v, %v, want %v", info, err, modTime)
+	}
+}
+
+func TestOpenThroughInnerSymlink(t *testing.T) {
+	fm, _ := newTestFM(t)
+
+	file, err := fm.Open("inner/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "inside" {
+		t.Errorf("data = %q, want %q", data, "inside")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	fm, _ := newTestFM(t)
+
+	// upload interrupted by crash
+	file, err := fm.Create("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	temp := file.(*writer).file.Name()
+	if filepath.Dir(temp) != filepath.Join(fm.Root, "dir") {
+		t.Errorf("temp file %v is not next to target", temp)
+	}
+	// move between filesystems interrupted by crash
+	tempDir, err := os.MkdirTemp(filepath.Join(fm.Root, "dir"), tempPrefix+"*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("partial"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fm.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(temp); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temp file not removed, stat err = %v", err)
+	}
+	if _, err := os.Stat(tempDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temp dir not removed, stat err = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(fm.Root, "dir/file.txt"))
+	if err != nil || string(data) != "inside" {
+		t.Errorf("target file = %q, %v, want %q", data, err, "inside")
+	}
+}
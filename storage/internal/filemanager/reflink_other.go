@@ -0,0 +1,12 @@
+//go:build !linux
+
+package filemanager
+
+import (
+	"os"
+	"syscall"
+)
+
+func reflink(dst, src *os.File) error {
+	return syscall.ENOTSUP
+}
@@ -0,0 +1,213 @@
+package filemanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+var _ backend.Watcher = (*FileManager)(nil)
+
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_CLOSE_WRITE | unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW
+
+// Watch reports changes of tree under Root with inotify. Temporary entries
+// are ignored, so committed upload is reported as file moved in. Overflow of
+// kernel queue is reported as OpLost of root. Entries made in new directory
+// while its watch is added can be reported as created twice.
+func (fm *FileManager) Watch(ctx context.Context, notify func(backend.Change)) error {
+	root, err := filepath.EvalSymlinks(fm.Root)
+	if err != nil {
+		return err
+	}
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	// non-blocking file is polled by runtime, Close interrupts Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	w := &inotify{
+		fd:     fd,
+		root:   root,
+		paths:  make(map[int32]string),
+		notify: notify,
+	}
+	if err := w.addTree("/", false); err != nil {
+		file.Close()
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		file.Close()
+	}()
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		w.handle(buf[:n])
+	}
+}
+
+type inotify struct {
+	fd   int
+	root string
+	// watch descriptor -> watched directory
+	paths  map[int32]string
+	notify func(backend.Change)
+	// source of move waiting for its destination
+	moved *movedFrom
+}
+
+type movedFrom struct {
+	cookie uint32
+	path   string
+	dir    bool
+}
+
+// watch directory and directories under it. Directory that just appeared
+// is filled before watch is added, with report its entries are reported as
+// created.
+func (w *inotify) addTree(dir string, report bool) error {
+	full := filepath.Join(w.root, filepath.FromSlash(dir))
+	wd, err := unix.InotifyAddWatch(w.fd, full, watchMask)
+	if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ENOTDIR) {
+		// removed or replaced meanwhile
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: full, Err: err}
+	}
+	w.paths[int32(wd)] = dir
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue
+		}
+		p := path.Join(dir, entry.Name())
+		if report {
+			w.notify(backend.Change{Op: backend.OpCreate, Path: p, Dir: entry.IsDir()})
+		}
+		if entry.IsDir() {
+			if err := w.addTree(p, report); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stop watching directories under dir, moved out of root
+func (w *inotify) removeTree(dir string) {
+	for wd, p := range w.paths {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.paths, wd)
+		}
+	}
+}
+
+// update watched paths of directory moved inside root
+func (w *inotify) renameTree(oldDir, newDir string) {
+	for wd, p := range w.paths {
+		if p == oldDir || strings.HasPrefix(p, oldDir+"/") {
+			w.paths[wd] = newDir + strings.TrimPrefix(p, oldDir)
+		}
+	}
+}
+
+// handle events read from inotify
+func (w *inotify) handle(buf []byte) {
+	for len(buf) >= unix.SizeofInotifyEvent {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := unix.SizeofInotifyEvent + int(event.Len)
+		name := buf[unix.SizeofInotifyEvent:end]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		w.event(event.Wd, event.Mask, event.Cookie, string(name))
+		buf = buf[end:]
+	}
+	// destination of move comes right after its source, source without
+	// destination was moved out of root
+	w.flushMoved()
+}
+
+func (w *inotify) event(wd int32, mask, cookie uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		w.flushMoved()
+		w.notify(backend.Change{Op: backend.OpLost, Path: "/"})
+		return
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		return
+	}
+	parent, ok := w.paths[wd]
+	if !ok || name == "" || strings.HasPrefix(name, tempPrefix) {
+		return
+	}
+	p := path.Join(parent, name)
+	dir := mask&unix.IN_ISDIR != 0
+
+	if mask&unix.IN_MOVED_TO != 0 && w.moved != nil && w.moved.cookie == cookie {
+		oldPath := w.moved.path
+		w.moved = nil
+		if dir {
+			w.renameTree(oldPath, p)
+		}
+		w.notify(backend.Change{Op: backend.OpMove, Path: p, OldPath: oldPath, Dir: dir})
+		return
+	}
+	w.flushMoved()
+
+	switch {
+	case mask&unix.IN_MOVED_FROM != 0:
+		w.moved = &movedFrom{cookie: cookie, path: p, dir: dir}
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		w.notify(backend.Change{Op: backend.OpCreate, Path: p, Dir: dir})
+		if dir {
+			// error means limit of watches, tree is watched partially
+			w.addTree(p, true)
+		}
+	case mask&unix.IN_DELETE != 0:
+		w.notify(backend.Change{Op: backend.OpRemove, Path: p, Dir: dir})
+	case mask&unix.IN_CLOSE_WRITE != 0:
+		w.notify(backend.Change{Op: backend.OpModify, Path: p})
+	}
+}
+
+// report pending source of move as removed
+func (w *inotify) flushMoved() {
+	if w.moved == nil {
+		return
+	}
+	moved := w.moved
+	w.moved = nil
+	if moved.dir {
+		w.removeTree(moved.path)
+	}
+	w.notify(backend.Change{Op: backend.OpRemove, Path: moved.path, Dir: moved.dir})
+}
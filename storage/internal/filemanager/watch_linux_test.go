@@ -0,0 +1,137 @@
+package filemanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	fm := &FileManager{Root: root}
+	changes := make(chan backend.Change, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- fm.Watch(ctx, func(change backend.Change) { changes <- change })
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// touch file until watching started
+	ready := filepath.Join(root, "ready")
+	for started := false; !started; {
+		if err := os.WriteFile(ready, nil, 0660); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-changes:
+			started = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	ops := []string{"create", "remove", "move", "modify"}
+	created := make(map[string]bool)
+	// wait for changes, directory must be watched before it's moved away
+	expect := func(want ...string) {
+		t.Helper()
+		for i := 0; i < len(want); {
+			select {
+			case change := <-changes:
+				got := fmt.Sprintf("%v %v", ops[change.Op], change.Path)
+				if change.OldPath != "" {
+					got = fmt.Sprintf("%v %v->%v", ops[change.Op], change.OldPath, change.Path)
+				}
+				if change.Dir {
+					got += " dir"
+				}
+				// writes of ready file and of touched file itself
+				if got == "create /ready" || got == "modify /ready" || got == "modify /a/b/file" {
+					continue
+				}
+				// entry made while watch of new directory is added is
+				// reported by both
+				if created[got] {
+					continue
+				}
+				if got != want[i] {
+					t.Errorf("change = %v, want %v", got, want[i])
+				}
+				if strings.HasPrefix(got, "create") {
+					created[got] = true
+				}
+				i++
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no change, want %v", want[i])
+			}
+		}
+	}
+
+	if err := os.Remove(ready); err != nil {
+		t.Fatal(err)
+	}
+	expect("remove /ready")
+	if err := fm.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	expect("create /dir dir")
+	writeFile(t, fm, "/dir/file", "content")
+	expect("create /dir/file")
+	if err := fm.Rename("/dir", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	expect("move /dir->/moved dir")
+	// watch of moved dir follows it
+	if err := os.WriteFile(filepath.Join(root, "moved", "file"), []byte("changed"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	expect("modify /moved/file")
+	if err := os.Rename(filepath.Join(root, "moved"), filepath.Join(t.TempDir(), "out")); err != nil {
+		t.Fatal(err)
+	}
+	expect("remove /moved dir")
+
+	// entries made before watch of new directory is added
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "b", "file"), nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	expect("create /a dir", "create /a/b dir", "create /a/b/file")
+}
+
+func writeFile(t *testing.T, b backend.Backend, path, content string) {
+	t.Helper()
+	file, err := b.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	changes := make([]backend.Change, 0)
+	w := &inotify{paths: make(map[int32]string), notify: func(change backend.Change) { changes = append(changes, change) }}
+	w.event(-1, unix.IN_Q_OVERFLOW, 0, "")
+	if len(changes) != 1 || changes[0].Op != backend.OpLost || changes[0].Path != "/" {
+		t.Errorf("changes on overflow = %v, want lost /", changes)
+	}
+}
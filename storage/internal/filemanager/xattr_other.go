@@ -0,0 +1,15 @@
+//go:build !linux
+
+package filemanager
+
+import (
+	"syscall"
+)
+
+func setxattr(path, name string, value []byte) error {
+	return syscall.ENOTSUP
+}
+
+func getxattr(path, name string) ([]byte, error) {
+	return nil, syscall.ENOTSUP
+}
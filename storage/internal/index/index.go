@@ -0,0 +1,479 @@
+// Package index keeps full-text inverted index of text files stored in a
+// backend. Index is changed by single background worker in order of calls,
+// queries see changes processed so far.
+package index
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+const (
+	// bigger files are not indexed
+	MaxFileSize = 16 << 20
+	// terms shorter or longer are skipped
+	minTermLen = 2
+	maxTermLen = 64
+	// how often changed index is saved
+	saveInterval = 10 * time.Second
+	// size of queue of changes, callers block when it is full
+	queueSize = 1024
+)
+
+type doc struct {
+	Path    string
+	ModTime int64
+	Size    int64
+	// terms of doc, to remove its postings
+	Terms []string
+}
+
+// persisted state
+type data struct {
+	Docs map[uint32]*doc
+	// term -> doc -> sorted line numbers, from 1
+	Postings map[string]map[uint32][]uint32
+	NextID   uint32
+}
+
+type Index struct {
+	b    backend.Backend
+	file string
+
+	mu     sync.RWMutex
+	data   data
+	byPath map[string]uint32
+	dirty  bool
+
+	queue chan func()
+	done  chan struct{}
+}
+
+// Hit is line of file containing all terms of query
+type Hit struct {
+	Path string
+	Line int
+}
+
+// Open loads index from file, if it exists, and starts worker. Empty file
+// keeps index only in memory. Whole backend is rescanned in background,
+// files changed since index was saved are indexed again.
+func Open(b backend.Backend, file string) (*Index, error) {
+	ix := &Index{
+		b:    b,
+		file: file,
+		data: data{
+			Docs:     make(map[uint32]*doc),
+			Postings: make(map[string]map[uint32][]uint32),
+		},
+		byPath: make(map[string]uint32),
+		queue:  make(chan func(), queueSize),
+		done:   make(chan struct{}),
+	}
+	if file != "" {
+		if err := ix.load(); err != nil {
+			return nil, err
+		}
+	}
+	go ix.run()
+	ix.Update("/")
+	return ix, nil
+}
+
+// Update indexes file again, for directory it indexes changed files of
+// subtree and drops removed ones. Missing path is removed from index.
+func (ix *Index) Update(path string) {
+	if ix == nil {
+		return
+	}
+	path = clean(path)
+	ix.queue <- func() {
+		if err := ix.update(path); err != nil {
+			log.Printf("index: update %v: %v", path, err)
+		}
+	}
+}
+
+// Remove drops path and its subtree from index
+func (ix *Index) Remove(path string) {
+	if ix == nil {
+		return
+	}
+	path = clean(path)
+	ix.queue <- func() {
+		ix.mu.Lock()
+		defer ix.mu.Unlock()
+		ix.removeTree(path)
+	}
+}
+
+// Rename moves indexed path and its subtree, replaced file is dropped
+func (ix *Index) Rename(oldpath, newpath string) {
+	if ix == nil {
+		return
+	}
+	oldpath, newpath = clean(oldpath), clean(newpath)
+	ix.queue <- func() {
+		ix.mu.Lock()
+		defer ix.mu.Unlock()
+		ix.removeTree(newpath)
+		for id, d := range ix.data.Docs {
+			if under(d.Path, oldpath) {
+				delete(ix.byPath, d.Path)
+				d.Path = newpath + strings.TrimPrefix(d.Path, oldpath)
+				ix.byPath[d.Path] = id
+			}
+		}
+		ix.dirty = true
+	}
+}
+
+// Sync waits until all changes queued before are processed
+func (ix *Index) Sync() {
+	if ix == nil {
+		return
+	}
+	done := make(chan struct{})
+	ix.queue <- func() { close(done) }
+	<-done
+}
+
+// Close processes queued changes, stops worker and saves index
+func (ix *Index) Close() error {
+	close(ix.queue)
+	<-ix.done
+	return ix.save()
+}
+
+// Search returns lines containing all terms of query in files under dir,
+// ordered by path and line. Limit 0 is unlimited.
+func (ix *Index) Search(query, dir string, limit int) []Hit {
+	dir = clean(dir)
+	terms := make([]string, 0)
+	seen := make(map[string]bool)
+	tokenize(query, func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	})
+	if len(terms) == 0 {
+		return nil
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	// intersect starting with rarest term
+	sort.Slice(terms, func(i, j int) bool {
+		return len(ix.data.Postings[terms[i]]) < len(ix.data.Postings[terms[j]])
+	})
+	hits := make([]Hit, 0)
+	for id, lines := range ix.data.Postings[terms[0]] {
+		d := ix.data.Docs[id]
+		if !under(d.Path, dir) {
+			continue
+		}
+		for _, term := range terms[1:] {
+			lines = intersect(lines, ix.data.Postings[term][id])
+		}
+		for _, line := range lines {
+			hits = append(hits, Hit{Path: d.Path, Line: int(line)})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Path != hits[j].Path {
+			return hits[i].Path < hits[j].Path
+		}
+		return hits[i].Line < hits[j].Line
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func (ix *Index) run() {
+	defer close(ix.done)
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case f, ok := <-ix.queue:
+			if !ok {
+				return
+			}
+			f()
+		case <-ticker.C:
+			if err := ix.save(); err != nil {
+				log.Printf("index: save: %v", err)
+			}
+		}
+	}
+}
+
+func (ix *Index) update(path string) error {
+	info, exist, err := ix.b.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		ix.mu.Lock()
+		ix.removeTree(path)
+		ix.mu.Unlock()
+		return nil
+	}
+	if !info.IsDir() {
+		return ix.indexFile(path, info)
+	}
+
+	seen := make(map[string]bool)
+	if err := ix.walk(path, seen); err != nil {
+		return err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, d := range ix.data.Docs {
+		if under(d.Path, path) && !seen[d.Path] {
+			ix.removeDoc(d.Path)
+		}
+	}
+	return nil
+}
+
+// index files of dir recursively, paths of indexed files are added to seen
+func (ix *Index) walk(dir string, seen map[string]bool) error {
+	files, dirs, err := ix.b.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		path := clean(file.Path)
+		info, exist, err := ix.b.Stat(path)
+		if err != nil || !exist || info.IsDir() {
+			continue
+		}
+		seen[path] = true
+		if err := ix.indexFile(path, info); err != nil {
+			log.Printf("index: %v: %v", path, err)
+		}
+	}
+	for _, d := range dirs {
+		if err := ix.walk(d.Path, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ix *Index) indexFile(path string, info fs.FileInfo) error {
+	ix.mu.RLock()
+	id, ok := ix.byPath[path]
+	unchanged := ok && ix.data.Docs[id].ModTime == info.ModTime().UnixNano() && ix.data.Docs[id].Size == info.Size()
+	ix.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	lines, err := ix.readTerms(path, info)
+	if err != nil {
+		return err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeDoc(path)
+	if lines == nil {
+		return nil
+	}
+
+	id = ix.data.NextID
+	ix.data.NextID++
+	d := &doc{Path: path, ModTime: info.ModTime().UnixNano(), Size: info.Size()}
+	for term, termLines := range lines {
+		postings, ok := ix.data.Postings[term]
+		if !ok {
+			postings = make(map[uint32][]uint32)
+			ix.data.Postings[term] = postings
+		}
+		postings[id] = termLines
+		d.Terms = append(d.Terms, term)
+	}
+	ix.data.Docs[id] = d
+	ix.byPath[path] = id
+	ix.dirty = true
+	return nil
+}
+
+// read file and return lines of its terms, nil if file is not text
+func (ix *Index) readTerms(path string, info fs.FileInfo) (map[string][]uint32, error) {
+	if info.Size() > MaxFileSize {
+		return nil, nil
+	}
+	file, err := ix.b.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(io.LimitReader(file, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > MaxFileSize || !isText(content) {
+		return nil, nil
+	}
+
+	lines := make(map[string][]uint32)
+	for i, line := range strings.Split(string(content), "\n") {
+		number := uint32(i + 1)
+		tokenize(line, func(term string) {
+			termLines := lines[term]
+			if len(termLines) == 0 || termLines[len(termLines)-1] != number {
+				lines[term] = append(termLines, number)
+			}
+		})
+	}
+	return lines, nil
+}
+
+// remove doc and docs under path, caller must hold lock
+func (ix *Index) removeTree(path string) {
+	for _, d := range ix.data.Docs {
+		if under(d.Path, path) {
+			ix.removeDoc(d.Path)
+		}
+	}
+}
+
+// caller must hold lock
+func (ix *Index) removeDoc(path string) {
+	id, ok := ix.byPath[path]
+	if !ok {
+		return
+	}
+	for _, term := range ix.data.Docs[id].Terms {
+		postings := ix.data.Postings[term]
+		delete(postings, id)
+		if len(postings) == 0 {
+			delete(ix.data.Postings, term)
+		}
+	}
+	delete(ix.data.Docs, id)
+	delete(ix.byPath, path)
+	ix.dirty = true
+}
+
+func (ix *Index) load() error {
+	file, err := os.Open(ix.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := gob.NewDecoder(file).Decode(&ix.data); err != nil {
+		// broken index is built again
+		log.Printf("index: load %v: %v", ix.file, err)
+		ix.data = data{Docs: make(map[uint32]*doc), Postings: make(map[string]map[uint32][]uint32)}
+		return nil
+	}
+	for id, d := range ix.data.Docs {
+		ix.byPath[d.Path] = id
+	}
+	return nil
+}
+
+// write index to temporary file and rename it over index file
+func (ix *Index) save() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.file == "" || !ix.dirty {
+		return nil
+	}
+	file, err := os.CreateTemp(filepath.Dir(ix.file), filepath.Base(ix.file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	err = gob.NewEncoder(file).Encode(&ix.data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), ix.file)
+	}
+	if err != nil {
+		return err
+	}
+	ix.dirty = false
+	return nil
+}
+
+// split text into lowercase terms of letters and digits
+func tokenize(text string, f func(term string)) {
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if n := utf8.RuneCountInString(word); n >= minTermLen && n <= maxTermLen {
+			f(strings.ToLower(word))
+		}
+	}
+}
+
+// text is valid UTF-8 without NUL bytes, cut rune at end is allowed
+func isText(content []byte) bool {
+	for len(content) > 0 {
+		r, size := utf8.DecodeRune(content)
+		if r == 0 || r == utf8.RuneError && size == 1 && (len(content) > 3 || utf8.FullRune(content)) {
+			return false
+		}
+		content = content[size:]
+	}
+	return true
+}
+
+// intersect sorted lists
+func intersect(a, b []uint32) []uint32 {
+	result := make([]uint32, 0)
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			a = a[1:]
+		case a[0] > b[0]:
+			b = b[1:]
+		default:
+			result = append(result, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return result
+}
+
+// path is dir or inside it
+func under(path, dir string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// path in form "/a/b", invalid paths are checked by backend later
+func clean(path string) string {
+	names, err := backend.Split("index", path)
+	if err != nil {
+		return path
+	}
+	return "/" + strings.Join(names, "/")
+}
@@ -0,0 +1,108 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+	"github.com/muskelo/ns_server/storage/internal/filemanager"
+)
+
+func writeFile(t *testing.T, b backend.Backend, path, content string) {
+	t.Helper()
+	file, err := b.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hits(ix *Index, query, dir string) string {
+	result := make([]string, 0)
+	for _, hit := range ix.Search(query, dir, 0) {
+		result = append(result, fmt.Sprintf("%v:%v", hit.Path, hit.Line))
+	}
+	return strings.Join(result, " ")
+}
+
+func TestIndex(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b := &filemanager.FileManager{Root: filepath.Join(root, "data")}
+	if err := b.Mkdir("/docs"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, b, "/docs/a.md", "# Title\nhello World\nworld peace\n")
+	writeFile(t, b, "/b.csv", "id,name\n1,hello\n")
+	writeFile(t, b, "/image.bin", "hello\x00world")
+	file := filepath.Join(root, "data.index")
+
+	ix, err := Open(b, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.Sync()
+	tests := []struct {
+		query, dir, want string
+	}{
+		{"world", "/", "/docs/a.md:2 /docs/a.md:3"},
+		{"HELLO", "/", "/b.csv:2 /docs/a.md:2"},
+		{"hello world", "/", "/docs/a.md:2"},
+		{"hello", "/docs", "/docs/a.md:2"},
+		{"hello", "/doc", ""},
+		{"missing hello", "/", ""},
+		{"a", "/", ""},
+	}
+	for _, test := range tests {
+		if got := hits(ix, test.query, test.dir); got != test.want {
+			t.Errorf("Search(%q, %q) = %v, want %v", test.query, test.dir, got, test.want)
+		}
+	}
+
+	writeFile(t, b, "/b.csv", "id,name\n2,bye\n")
+	ix.Update("/b.csv")
+	ix.Rename("/docs", "/notes")
+	ix.Sync()
+	if got, want := hits(ix, "hello", "/"), "/notes/a.md:2"; got != want {
+		t.Errorf("Search after update and rename = %v, want %v", got, want)
+	}
+	if err := ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// files changed while index is closed are found by rescan
+	if err := b.Remove("/b.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Rename("/docs", "/notes"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, b, "/c.txt", "bye bye\n")
+	ix, err = Open(b, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	ix.Sync()
+	if got, want := hits(ix, "bye", "/"), "/c.txt:1"; got != want {
+		t.Errorf("Search after reopen = %v, want %v", got, want)
+	}
+	if got, want := hits(ix, "peace", "/"), "/notes/a.md:3"; got != want {
+		t.Errorf("Search after reopen = %v, want %v", got, want)
+	}
+	ix.Remove("/notes")
+	ix.Sync()
+	if got := hits(ix, "peace", "/"); got != "" {
+		t.Errorf("Search after remove = %v, want nothing", got)
+	}
+}
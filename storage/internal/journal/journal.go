@@ -0,0 +1,373 @@
+// Package journal keeps ordered log of changes for incremental sync. Log is
+// file of JSON lines, header with epoch followed by entries appended as
+// changes happen. Cursor is epoch and sequence number of last seen entry,
+// entries dropped by retention or journal created anew make it expired.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+var (
+	// cursor is not one returned by journal
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// entries after cursor are dropped or journal was recreated, client
+	// must list everything again
+	ErrExpired = errors.New("cursor expired")
+)
+
+var opNames = map[backend.ChangeOp]string{
+	backend.OpCreate: "create",
+	backend.OpRemove: "remove",
+	backend.OpMove:   "move",
+	backend.OpModify: "modify",
+}
+
+// Entry is change recorded in journal
+type Entry struct {
+	Seq uint64
+	// unix milliseconds
+	Time   int64
+	Change backend.Change
+}
+
+// line of journal file
+type record struct {
+	// only in header
+	Epoch string `json:"epoch,omitempty"`
+	// in header sequence number of first entry after it
+	Seq     uint64 `json:"seq"`
+	Time    int64  `json:"time,omitempty"`
+	Op      string `json:"op,omitempty"`
+	Path    string `json:"path,omitempty"`
+	OldPath string `json:"old_path,omitempty"`
+	Dir     bool   `json:"dir,omitempty"`
+}
+
+type Journal struct {
+	file      string
+	retention time.Duration
+	max       int
+
+	mu    sync.Mutex
+	epoch string
+	// retained entries, in order of Seq. Entry failed to be written leaves
+	// gap in Seq after restart.
+	entries []Entry
+	next    uint64
+	// opened for appending, nil if journal is only in memory
+	out *os.File
+	// lines in file, to compact it when it is twice retained entries
+	lines int
+}
+
+// Open loads journal from file or creates it. Empty file keeps journal
+// only in memory. Entries older than retention or over max entries are
+// dropped, 0 is unlimited.
+func Open(file string, retention time.Duration, max int) (*Journal, error) {
+	j := &Journal{
+		file:      file,
+		retention: retention,
+		max:       max,
+		next:      1,
+	}
+	if file == "" {
+		j.epoch = newEpoch()
+		return j, nil
+	}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	j.expire(time.Now())
+	// header of new journal, drop torn line left by crash
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func newEpoch() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (j *Journal) load() error {
+	data, err := os.ReadFile(j.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		j.epoch = newEpoch()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			// only last line can be torn by crash
+			if i == bytes.Count(data, []byte("\n")) {
+				break
+			}
+			return fmt.Errorf("journal %v line %v: %w", j.file, i+1, err)
+		}
+		if i == 0 {
+			if r.Epoch == "" {
+				return fmt.Errorf("journal %v: missing header", j.file)
+			}
+			j.epoch = r.Epoch
+			j.next = r.Seq
+			continue
+		}
+		op, ok := parseOp(r.Op)
+		if !ok || r.Seq < j.next {
+			return fmt.Errorf("journal %v line %v: invalid entry", j.file, i+1)
+		}
+		j.entries = append(j.entries, Entry{
+			Seq:    r.Seq,
+			Time:   r.Time,
+			Change: backend.Change{Op: op, Path: r.Path, OldPath: r.OldPath, Dir: r.Dir},
+		})
+		j.next = r.Seq + 1
+	}
+	if j.epoch == "" {
+		// empty file
+		j.epoch = newEpoch()
+	}
+	return nil
+}
+
+func parseOp(name string) (backend.ChangeOp, bool) {
+	for op, n := range opNames {
+		if n == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+// Append records change. Nil journal does nothing.
+func (j *Journal) Append(change backend.Change) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := Entry{Seq: j.next, Time: time.Now().UnixMilli(), Change: change}
+	entry.Change.Path = clean(change.Path)
+	if change.OldPath != "" {
+		entry.Change.OldPath = clean(change.OldPath)
+	}
+	j.entries = append(j.entries, entry)
+	j.next++
+	if j.max > 0 && len(j.entries) > j.max {
+		j.entries = j.entries[len(j.entries)-j.max:]
+	}
+	if j.out == nil {
+		return
+	}
+
+	line, err := json.Marshal(newRecord(entry))
+	if err == nil {
+		_, err = j.out.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = j.out.Sync()
+	}
+	if err != nil {
+		log.Printf("journal: append: %v", err)
+		return
+	}
+	j.lines++
+	if j.lines > 2*len(j.entries)+1 {
+		if err := j.compact(); err != nil {
+			log.Printf("journal: compact: %v", err)
+		}
+	}
+}
+
+func newRecord(entry Entry) record {
+	return record{
+		Seq:     entry.Seq,
+		Time:    entry.Time,
+		Op:      opNames[entry.Change.Op],
+		Path:    entry.Change.Path,
+		OldPath: entry.Change.OldPath,
+		Dir:     entry.Change.Dir,
+	}
+}
+
+// Cursor returns cursor after all recorded changes
+func (j *Journal) Cursor() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cursor(j.next - 1)
+}
+
+func (j *Journal) cursor(seq uint64) string {
+	return j.epoch + "-" + strconv.FormatUint(seq, 36)
+}
+
+// List returns entries after cursor changing dir or paths under it, at
+// most limit of them if it isn't 0, and cursor after them. More is true if
+// entries after returned cursor were not listed because of limit.
+func (j *Journal) List(cursor, dir string, limit int) (entries []Entry, next string, more bool, err error) {
+	epoch, seqText, ok := strings.Cut(cursor, "-")
+	seq, err := strconv.ParseUint(seqText, 36, 64)
+	if !ok || err != nil {
+		return nil, "", false, ErrInvalidCursor
+	}
+	dir = clean(dir)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if epoch != j.epoch || seq+1 < j.first() || seq >= j.next {
+		return nil, "", false, ErrExpired
+	}
+	entries = make([]Entry, 0)
+	i := sort.Search(len(j.entries), func(i int) bool { return j.entries[i].Seq > seq })
+	for _, entry := range j.entries[i:] {
+		if limit > 0 && len(entries) == limit {
+			return entries, j.cursor(seq), true, nil
+		}
+		if under(entry.Change.Path, dir) || (entry.Change.OldPath != "" && under(entry.Change.OldPath, dir)) {
+			entries = append(entries, entry)
+		}
+		seq = entry.Seq
+	}
+	return entries, j.cursor(seq), false, nil
+}
+
+// sequence number of first retained entry, caller must hold j.mu
+func (j *Journal) first() uint64 {
+	if len(j.entries) == 0 {
+		return j.next
+	}
+	return j.entries[0].Seq
+}
+
+// Sweep drops entries past retention and rewrites file without them
+func (j *Journal) Sweep() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.expire(time.Now())
+	if j.file == "" {
+		return nil
+	}
+	return j.compact()
+}
+
+// drop entries older than retention, caller must hold j.mu
+func (j *Journal) expire(now time.Time) {
+	if j.max > 0 && len(j.entries) > j.max {
+		j.entries = j.entries[len(j.entries)-j.max:]
+	}
+	if j.retention <= 0 {
+		return
+	}
+	oldest := now.Add(-j.retention).UnixMilli()
+	i := 0
+	for i < len(j.entries) && j.entries[i].Time < oldest {
+		i++
+	}
+	j.entries = j.entries[i:]
+}
+
+// compact writes header and retained entries to new file replacing old
+// one, caller must hold j.mu
+func (j *Journal) compact() error {
+	file, err := os.CreateTemp(filepath.Dir(j.file), filepath.Base(j.file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	err = encoder.Encode(record{Epoch: j.epoch, Seq: j.first()})
+	for _, entry := range j.entries {
+		if err == nil {
+			err = encoder.Encode(newRecord(entry))
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), j.file)
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(j.file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if j.out != nil {
+		j.out.Close()
+	}
+	j.out = out
+	j.lines = len(j.entries) + 1
+	return nil
+}
+
+// Reset drops all entries and starts new epoch, so every cursor expires.
+// It's used when changes were lost and journal can't tell what changed.
+func (j *Journal) Reset() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.epoch = newEpoch()
+	j.entries = nil
+	if j.file == "" {
+		return nil
+	}
+	return j.compact()
+}
+
+// Close closes journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.out == nil {
+		return nil
+	}
+	err := j.out.Close()
+	j.out = nil
+	return err
+}
+
+func under(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+func clean(p string) string {
+	return path.Join("/", p)
+}
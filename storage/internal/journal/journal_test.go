@@ -0,0 +1,147 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+func paths(entries []Entry) string {
+	result := make([]string, 0)
+	for _, entry := range entries {
+		result = append(result, entry.Change.Path)
+	}
+	return strings.Join(result, " ")
+}
+
+func TestList(t *testing.T) {
+	j, err := Open("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := j.Cursor()
+	for _, p := range []string{"/a", "/dir/b", "/dir/c", "dir/sub/d"} {
+		j.Append(backend.Change{Op: backend.OpCreate, Path: p})
+	}
+	j.Append(backend.Change{Op: backend.OpMove, Path: "/e", OldPath: "/dir/c"})
+
+	entries, cursor, more, err := j.List(start, "/", 0)
+	if err != nil || more || paths(entries) != "/a /dir/b /dir/c /dir/sub/d /e" {
+		t.Fatalf("List = %v %v, %v", paths(entries), more, err)
+	}
+	if cursor != j.Cursor() {
+		t.Errorf("cursor after all entries = %v, want %v", cursor, j.Cursor())
+	}
+	if entries, _, _, err := j.List(cursor, "/", 0); err != nil || len(entries) != 0 {
+		t.Errorf("List after last = %v, %v", paths(entries), err)
+	}
+
+	// limit counts listed entries, filtered ones are skipped
+	entries, cursor, more, err = j.List(start, "/dir", 2)
+	if err != nil || !more || paths(entries) != "/dir/b /dir/c" {
+		t.Fatalf("List of dir = %v %v, %v", paths(entries), more, err)
+	}
+	entries, _, more, err = j.List(cursor, "/dir", 2)
+	if err != nil || more || paths(entries) != "/dir/sub/d /e" {
+		t.Errorf("List of dir after cursor = %v %v, %v", paths(entries), more, err)
+	}
+
+	cursor = j.Cursor()
+	if err := j.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := j.List(cursor, "/", 0); !errors.Is(err, ErrExpired) {
+		t.Errorf("List after reset err = %v, want ErrExpired", err)
+	}
+
+	for _, cursor := range []string{"", "x", start + "z"} {
+		if _, _, _, err := j.List(cursor, "/", 0); !errors.Is(err, ErrInvalidCursor) && !errors.Is(err, ErrExpired) {
+			t.Errorf("List(%q) err = %v", cursor, err)
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	j, err := Open("", time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := j.Cursor()
+	j.Append(backend.Change{Op: backend.OpCreate, Path: "/a"})
+	after := j.Cursor()
+	j.Append(backend.Change{Op: backend.OpCreate, Path: "/b"})
+	j.Append(backend.Change{Op: backend.OpCreate, Path: "/c"})
+
+	if _, _, _, err := j.List(start, "/", 0); !errors.Is(err, ErrExpired) {
+		t.Errorf("List of dropped entry err = %v, want ErrExpired", err)
+	}
+	entries, _, _, err := j.List(after, "/", 0)
+	if err != nil || paths(entries) != "/b /c" {
+		t.Errorf("List = %v, %v", paths(entries), err)
+	}
+
+	j.entries[0].Time = time.Now().Add(-2 * time.Hour).UnixMilli()
+	if err := j.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := j.List(after, "/", 0); !errors.Is(err, ErrExpired) {
+		t.Errorf("List of expired entry err = %v, want ErrExpired", err)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "journal")
+	j, err := Open(file, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := j.Cursor()
+	for _, p := range []string{"/a", "/b", "/c", "/d", "/e", "/f", "/g"} {
+		j.Append(backend.Change{Op: backend.OpModify, Path: p})
+	}
+	cursor := j.Cursor()
+	j.Append(backend.Change{Op: backend.OpRemove, Path: "/h", Dir: true})
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// torn line of crash
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.WriteString(`{"seq":9,"ti`)
+	out.Close()
+
+	j, err = Open(file, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	entries, _, _, err := j.List(cursor, "/", 0)
+	if err != nil || len(entries) != 1 || entries[0].Change.Op != backend.OpRemove || !entries[0].Change.Dir {
+		t.Errorf("List after reopen = %v, %v", entries, err)
+	}
+	if _, _, _, err := j.List(start, "/", 0); !errors.Is(err, ErrExpired) {
+		t.Errorf("List of dropped entry err = %v, want ErrExpired", err)
+	}
+	j.Append(backend.Change{Op: backend.OpCreate, Path: "/i"})
+	entries, _, _, err = j.List(cursor, "/", 0)
+	if err != nil || paths(entries) != "/h /i" {
+		t.Errorf("List = %v, %v", paths(entries), err)
+	}
+
+	// cursor of other journal
+	other, err := Open(filepath.Join(t.TempDir(), "journal"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, _, _, err := other.List(cursor, "/", 0); !errors.Is(err, ErrExpired) {
+		t.Errorf("List of cursor of other journal err = %v, want ErrExpired", err)
+	}
+}
@@ -0,0 +1,357 @@
+// Package memory implements backend.Backend in memory, for tests and
+// ephemeral storage nodes. Nothing is persisted.
+package memory
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+var (
+	_ backend.Backend  = (*Memory)(nil)
+	_ backend.Digester = (*Memory)(nil)
+)
+
+type node struct {
+	name     string
+	dir      bool
+	data     []byte
+	modTime  time.Time
+	children map[string]*node
+	// algorithm -> digest, new content is new node without digests
+	digests map[string]string
+}
+
+func newDir(name string) *node {
+	return &node{
+		name:     name,
+		dir:      true,
+		modTime:  time.Now(),
+		children: make(map[string]*node),
+	}
+}
+
+type Memory struct {
+	mu   sync.RWMutex
+	root *node
+}
+
+func New() *Memory {
+	return &Memory{
+		root: newDir("/"),
+	}
+}
+
+// find node by names, caller must hold lock
+func (m *Memory) lookup(names []string) (*node, bool) {
+	current := m.root
+	for _, name := range names {
+		if !current.dir {
+			return nil, false
+		}
+		next, ok := current.children[name]
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return current, true
+}
+
+// find parent directory of last name, caller must hold lock
+func (m *Memory) parent(op, path string, names []string) (*node, error) {
+	if len(names) == 0 {
+		return nil, &fs.PathError{Op: op, Path: path, Err: backend.ErrInvalidPath}
+	}
+	dir, ok := m.lookup(names[:len(names)-1])
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+	}
+	if !dir.dir {
+		return nil, &fs.PathError{Op: op, Path: path, Err: syscall.ENOTDIR}
+	}
+	return dir, nil
+}
+
+func (m *Memory) ReadDir(path string) ([]backend.File, []backend.Directory, error) {
+	names, err := backend.Split("readdir", path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dir, ok := m.lookup(names)
+	if !ok {
+		return nil, nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	}
+	if !dir.dir {
+		return nil, nil, &fs.PathError{Op: "readdir", Path: path, Err: syscall.ENOTDIR}
+	}
+
+	children := make([]*node, 0, len(dir.children))
+	for _, child := range dir.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].name < children[j].name
+	})
+
+	filesList := make([]backend.File, 0)
+	dirsList := make([]backend.Directory, 0)
+	for _, child := range children {
+		if child.dir {
+			dirsList = append(dirsList, backend.Directory{
+				Name: child.name,
+				Path: filepath.Join(path, child.name),
+			})
+		} else {
+			filesList = append(filesList, backend.File{
+				Name: child.name,
+				Path: filepath.Join(path, child.name),
+			})
+		}
+	}
+	return filesList, dirsList, nil
+}
+
+func (m *Memory) Mkdir(path string) error {
+	names, err := backend.Split("mkdir", path)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, err := m.parent("mkdir", path, names)
+	if err != nil {
+		return err
+	}
+	name := names[len(names)-1]
+	if _, ok := dir.children[name]; ok {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: fs.ErrExist}
+	}
+	dir.children[name] = newDir(name)
+	dir.modTime = time.Now()
+	return nil
+}
+
+func (m *Memory) Open(path string) (io.ReadCloser, error) {
+	names, err := backend.Split("open", path)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	file, ok := m.lookup(names)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	if file.dir {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: syscall.EISDIR}
+	}
+	// data is never modified, only replaced
+	return io.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+// Create buffers written data, Commit replaces file with it
+func (m *Memory) Create(path string) (backend.Writer, error) {
+	names, err := backend.Split("create", path)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, err := m.createTarget(path, names); err != nil {
+		return nil, err
+	}
+	return &writer{m: m, path: path, names: names}, nil
+}
+
+// find directory where file can be created, caller must hold lock
+func (m *Memory) createTarget(path string, names []string) (*node, error) {
+	dir, err := m.parent("create", path, names)
+	if err != nil {
+		return nil, err
+	}
+	if file, ok := dir.children[names[len(names)-1]]; ok && file.dir {
+		return nil, &fs.PathError{Op: "create", Path: path, Err: syscall.EISDIR}
+	}
+	return dir, nil
+}
+
+func (m *Memory) Remove(path string) error {
+	names, err := backend.Split("remove", path)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, err := m.parent("remove", path, names)
+	if err != nil {
+		return err
+	}
+	name := names[len(names)-1]
+	target, ok := dir.children[name]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	if target.dir && len(target.children) > 0 {
+		return &fs.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+	}
+	delete(dir.children, name)
+	dir.modTime = time.Now()
+	return nil
+}
+
+func (m *Memory) Stat(path string) (fs.FileInfo, bool, error) {
+	names, err := backend.Split("stat", path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, ok := m.lookup(names)
+	if !ok {
+		return nil, false, nil
+	}
+	return n.info(), true, nil
+}
+
+func (m *Memory) Rename(oldpath, newpath string) error {
+	oldNames, newNames, err := backend.SplitRename(oldpath, newpath)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldDir, err := m.parent("rename", oldpath, oldNames)
+	if err != nil {
+		return err
+	}
+	oldName := oldNames[len(oldNames)-1]
+	target, ok := oldDir.children[oldName]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	newDir, err := m.parent("rename", newpath, newNames)
+	if err != nil {
+		return err
+	}
+	newName := newNames[len(newNames)-1]
+	if existing, ok := newDir.children[newName]; ok {
+		if existing == target {
+			return nil
+		}
+		if existing.dir || target.dir {
+			return &fs.PathError{Op: "rename", Path: newpath, Err: fs.ErrExist}
+		}
+	}
+
+	delete(oldDir.children, oldName)
+	target.name = newName
+	newDir.children[newName] = target
+	oldDir.modTime = time.Now()
+	newDir.modTime = time.Now()
+	return nil
+}
+
+func (m *Memory) SetDigest(path, algorithm, digest string) error {
+	names, err := backend.Split("setdigest", path)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.lookup(names)
+	if !ok {
+		return &fs.PathError{Op: "setdigest", Path: path, Err: fs.ErrNotExist}
+	}
+	if n.dir {
+		return &fs.PathError{Op: "setdigest", Path: path, Err: syscall.EISDIR}
+	}
+	if n.digests == nil {
+		n.digests = make(map[string]string)
+	}
+	n.digests[algorithm] = digest
+	return nil
+}
+
+func (m *Memory) Digest(path, algorithm string) (string, error) {
+	names, err := backend.Split("digest", path)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, ok := m.lookup(names)
+	if !ok {
+		return "", &fs.PathError{Op: "digest", Path: path, Err: fs.ErrNotExist}
+	}
+	return n.digests[algorithm], nil
+}
+
+// snapshot of node, caller must hold lock
+func (n *node) info() fs.FileInfo {
+	return backend.NewFileInfo(n.name, int64(len(n.data)), n.dir, n.modTime)
+}
+
+type writer struct {
+	m     *Memory
+	path  string
+	names []string
+	data  []byte
+	done  bool
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if w.done {
+		return 0, fs.ErrClosed
+	}
+	w.data = append(w.data, b...)
+	return len(b), nil
+}
+
+func (w *writer) Commit() error {
+	if w.done {
+		return fs.ErrClosed
+	}
+	w.done = true
+
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	// directory could be changed while writing
+	dir, err := w.m.createTarget(w.path, w.names)
+	if err != nil {
+		return err
+	}
+	name := w.names[len(w.names)-1]
+	dir.children[name] = &node{
+		name:    name,
+		data:    w.data,
+		modTime: time.Now(),
+	}
+	dir.modTime = time.Now()
+	return nil
+}
+
+func (w *writer) Abort() error {
+	w.done = true
+	w.data = nil
+	return nil
+}
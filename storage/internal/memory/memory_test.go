@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+	"github.com/muskelo/ns_server/storage/internal/backend/backendtest"
+)
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		return New()
+	})
+}
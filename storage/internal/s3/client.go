@@ -0,0 +1,305 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is error response of S3 API
+type Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3: %v %v: %v", e.StatusCode, e.Code, e.Message)
+}
+
+// Is makes errors.Is(err, fs.ErrNotExist) work for missing keys
+func (e *Error) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+type object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type listResult struct {
+	Contents       []object `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type initiateMultipartResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// build and send signed request for key, key "" is bucket itself
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + s.Bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		r.ContentLength = size
+	}
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	sign(r, s.Region, s.AccessKey, s.SecretKey, time.Now())
+
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		e := &Error{StatusCode: response.StatusCode}
+		// HEAD responses have no body
+		data, _ := io.ReadAll(response.Body)
+		xml.Unmarshal(data, e)
+		if e.Code == "" {
+			e.Code = http.StatusText(response.StatusCode)
+		}
+		return nil, e
+	}
+	return response, nil
+}
+
+func (s *S3) doXML(ctx context.Context, method, key string, query url.Values, body []byte, result any) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	response, err := s.do(ctx, method, key, query, nil, reader, int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if result == nil {
+		_, err = io.Copy(io.Discard, response.Body)
+		return err
+	}
+	return xml.NewDecoder(response.Body).Decode(result)
+}
+
+// prefix of headers with user metadata of object
+const metaPrefix = "X-Amz-Meta-"
+
+// head object, metadata names are lower case without metaPrefix
+func (s *S3) headObject(ctx context.Context, key string) (size int64, modTime time.Time, metadata map[string]string, err error) {
+	response, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return 0, time.Time{}, nil, err
+	}
+	response.Body.Close()
+	modTime, _ = http.ParseTime(response.Header.Get("Last-Modified"))
+	metadata = make(map[string]string)
+	for name, values := range response.Header {
+		if strings.HasPrefix(name, metaPrefix) && len(values) > 0 {
+			metadata[strings.ToLower(strings.TrimPrefix(name, metaPrefix))] = values[0]
+		}
+	}
+	return response.ContentLength, modTime, metadata, nil
+}
+
+// headers that set user metadata of object
+func metadataHeader(header http.Header, metadata map[string]string) http.Header {
+	if header == nil {
+		header = make(http.Header)
+	}
+	for name, value := range metadata {
+		header.Set(metaPrefix+name, value)
+	}
+	return header
+}
+
+// get object, header can select range of it
+func (s *S3) getObject(ctx context.Context, key string, header http.Header) (io.ReadCloser, error) {
+	response, err := s.do(ctx, http.MethodGet, key, nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+func (s *S3) putObject(ctx context.Context, key string, data []byte) error {
+	return s.doXML(ctx, http.MethodPut, key, nil, data, nil)
+}
+
+func (s *S3) deleteObject(ctx context.Context, key string) error {
+	return s.doXML(ctx, http.MethodDelete, key, nil, nil, nil)
+}
+
+// list one page of objects under prefix, delimiter "/" groups subdirectories,
+// empty delimiter lists whole subtree
+func (s *S3) listObjects(ctx context.Context, prefix, delimiter, token string, maxKeys int) (*listResult, error) {
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {prefix},
+	}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	result := &listResult{}
+	err := s.doXML(ctx, http.MethodGet, "", query, nil, result)
+	return result, err
+}
+
+// value of X-Amz-Copy-Source header
+func (s *S3) copySource(key string) string {
+	return escapePath("/" + s.Bucket + "/" + key)
+}
+
+// copy object inside bucket on server side, objects up to MaxCopySize only.
+// Metadata of source is kept if metadata is nil and replaced otherwise.
+func (s *S3) copyObject(ctx context.Context, srcKey, dstKey string, metadata map[string]string) error {
+	header := http.Header{"X-Amz-Copy-Source": {s.copySource(srcKey)}}
+	if metadata != nil {
+		header = metadataHeader(header, metadata)
+		header.Set("X-Amz-Metadata-Directive", "REPLACE")
+	}
+	response, err := s.do(ctx, http.MethodPut, dstKey, nil, header, bytes.NewReader(nil), 0)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// S3 may answer 200 with error in body
+	result := &struct {
+		XMLName xml.Name
+		Error
+	}{}
+	if err := xml.NewDecoder(response.Body).Decode(result); err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		result.Error.StatusCode = http.StatusOK
+		return &result.Error
+	}
+	return nil
+}
+
+// start multipart upload of object with metadata
+func (s *S3) createMultipartUpload(ctx context.Context, key string, metadata map[string]string) (string, error) {
+	response, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, metadataHeader(nil, metadata), bytes.NewReader(nil), 0)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	result := &initiateMultipartResult{}
+	err = xml.NewDecoder(response.Body).Decode(result)
+	return result.UploadID, err
+}
+
+func (s *S3) uploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(number)},
+		"uploadId":   {uploadID},
+	}
+	response, err := s.do(ctx, http.MethodPut, key, query, nil, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	return response.Header.Get("ETag"), nil
+}
+
+// copy length bytes of object from offset as part of multipart upload
+func (s *S3) uploadPartCopy(ctx context.Context, srcKey, key, uploadID string, number int, offset, length int64) (string, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(number)},
+		"uploadId":   {uploadID},
+	}
+	header := http.Header{
+		"X-Amz-Copy-Source":       {s.copySource(srcKey)},
+		"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)},
+	}
+	response, err := s.do(ctx, http.MethodPut, key, query, header, bytes.NewReader(nil), 0)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	// S3 may answer 200 with error in body
+	result := &struct {
+		XMLName xml.Name
+		Error
+		ETag string `xml:"ETag"`
+	}{}
+	if err := xml.NewDecoder(response.Body).Decode(result); err != nil {
+		return "", err
+	}
+	if result.XMLName.Local == "Error" {
+		result.Error.StatusCode = http.StatusOK
+		return "", &result.Error
+	}
+	return result.ETag, nil
+}
+
+func (s *S3) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(&completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	// S3 may answer 200 with error in body
+	result := &struct {
+		XMLName xml.Name
+		Error
+	}{}
+	err = s.doXML(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, result)
+	if err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		result.Error.StatusCode = http.StatusOK
+		return &result.Error
+	}
+	return nil
+}
+
+func (s *S3) abortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	return s.doXML(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+}
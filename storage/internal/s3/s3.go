@@ -0,0 +1,609 @@
+// Package s3 implements backend.Backend on top of S3-compatible object store.
+//
+// File is stored as object with key "<Prefix><path>". Directory is marker
+// object "<Prefix><path>/" with empty body; prefixes without marker, e.g.
+// created by other tools, are treated as directories too.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+var (
+	_ backend.Backend     = (*S3)(nil)
+	_ backend.Copier      = (*S3)(nil)
+	_ backend.Digester    = (*S3)(nil)
+	_ backend.RangeOpener = (*S3)(nil)
+)
+
+// DefaultPartSize is used when S3.PartSize is 0, S3 requires at least 5 MiB
+const DefaultPartSize = 8 << 20
+
+// MaxCopySize is the largest object S3 copies with single request, it is
+// used when S3.CopyPartSize is 0
+const MaxCopySize = 5 << 30
+
+type S3 struct {
+	// e.g. http://minio:9000, bucket is addressed in path
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// key prefix of backend root inside bucket, e.g. "ns/"
+	Prefix string
+	// streams bigger than PartSize are sent with multipart upload
+	PartSize int
+	// objects bigger than CopyPartSize are copied with multipart upload in
+	// parts of that size
+	CopyPartSize int64
+	HTTPClient   *http.Client
+}
+
+// key of path, root is prefix
+func (s *S3) key(names []string) string {
+	prefix := s.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + strings.Join(names, "/")
+}
+
+// key prefix of directory children
+func (s *S3) dirKey(names []string) string {
+	key := s.key(names)
+	if len(names) == 0 {
+		return key
+	}
+	return key + "/"
+}
+
+func (s *S3) partSize() int {
+	if s.PartSize > 0 {
+		return s.PartSize
+	}
+	return DefaultPartSize
+}
+
+func (s *S3) copyPartSize() int64 {
+	if s.CopyPartSize > 0 {
+		return s.CopyPartSize
+	}
+	return MaxCopySize
+}
+
+// stat entry by names, info is nil if not exist
+func (s *S3) stat(ctx context.Context, names []string) (fs.FileInfo, error) {
+	if len(names) == 0 {
+		return backend.NewFileInfo("/", 0, true, time.Time{}), nil
+	}
+	name := names[len(names)-1]
+
+	size, modTime, _, err := s.headObject(ctx, s.key(names))
+	if err == nil {
+		return backend.NewFileInfo(name, size, false, modTime), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	_, modTime, _, err = s.headObject(ctx, s.dirKey(names))
+	if err == nil {
+		return backend.NewFileInfo(name, 0, true, modTime), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// directory without marker
+	result, err := s.listObjects(ctx, s.dirKey(names), "/", "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Contents) > 0 || len(result.CommonPrefixes) > 0 {
+		return backend.NewFileInfo(name, 0, true, time.Time{}), nil
+	}
+	return nil, nil
+}
+
+// check that parent of names is existing directory
+func (s *S3) checkParent(ctx context.Context, op, path string, names []string) error {
+	if len(names) == 0 {
+		return &fs.PathError{Op: op, Path: path, Err: backend.ErrInvalidPath}
+	}
+	info, err := s.stat(ctx, names[:len(names)-1])
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: op, Path: path, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+func (s *S3) ReadDir(path string) ([]backend.File, []backend.Directory, error) {
+	names, err := backend.Split("readdir", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx := context.Background()
+	info, err := s.stat(ctx, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info == nil {
+		return nil, nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	}
+	if !info.IsDir() {
+		return nil, nil, &fs.PathError{Op: "readdir", Path: path, Err: syscall.ENOTDIR}
+	}
+
+	prefix := s.dirKey(names)
+	filesList := make([]backend.File, 0)
+	dirsList := make([]backend.Directory, 0)
+	token := ""
+	for {
+		result, err := s.listObjects(ctx, prefix, "/", token, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, prefix)
+			if name == "" {
+				// marker of directory itself
+				continue
+			}
+			filesList = append(filesList, backend.File{
+				Name: name,
+				Path: filepath.Join(path, name),
+			})
+		}
+		for _, common := range result.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(common.Prefix, prefix), "/")
+			dirsList = append(dirsList, backend.Directory{
+				Name: name,
+				Path: filepath.Join(path, name),
+			})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return filesList, dirsList, nil
+}
+
+func (s *S3) Mkdir(path string) error {
+	names, err := backend.Split("mkdir", path)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := s.checkParent(ctx, "mkdir", path, names); err != nil {
+		return err
+	}
+	info, err := s.stat(ctx, names)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: fs.ErrExist}
+	}
+	return s.putObject(ctx, s.dirKey(names), []byte{})
+}
+
+func (s *S3) Open(path string) (io.ReadCloser, error) {
+	names, err := backend.Split("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: syscall.EISDIR}
+	}
+	body, err := s.getObject(context.Background(), s.key(names), nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	return body, nil
+}
+
+// OpenRange gets only requested bytes of object
+func (s *S3) OpenRange(path string, offset, length int64) (io.ReadCloser, error) {
+	names, err := backend.Split("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: syscall.EISDIR}
+	}
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	body, err := s.getObject(context.Background(), s.key(names), header)
+	var s3Err *Error
+	if errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// offset is at or past end of object
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	return body, nil
+}
+
+// Create returns writer that stores object on Commit. Streams bigger than
+// PartSize are sent with multipart upload while writing, object appears
+// only when upload is completed.
+func (s *S3) Create(path string) (backend.Writer, error) {
+	names, err := backend.Split("create", path)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if err := s.checkParent(ctx, "create", path, names); err != nil {
+		return nil, err
+	}
+	info, err := s.stat(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil && info.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: path, Err: syscall.EISDIR}
+	}
+	return &writer{s: s, ctx: ctx, key: s.key(names)}, nil
+}
+
+func (s *S3) Remove(path string) error {
+	names, err := backend.Split("remove", path)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return &fs.PathError{Op: "remove", Path: path, Err: backend.ErrInvalidPath}
+	}
+	ctx := context.Background()
+	info, err := s.stat(ctx, names)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	if !info.IsDir() {
+		return s.deleteObject(ctx, s.key(names))
+	}
+
+	prefix := s.dirKey(names)
+	result, err := s.listObjects(ctx, prefix, "/", "", 2)
+	if err != nil {
+		return err
+	}
+	if len(result.CommonPrefixes) > 0 {
+		return &fs.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+	}
+	for _, object := range result.Contents {
+		if object.Key != prefix {
+			return &fs.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+		}
+	}
+	return s.deleteObject(ctx, prefix)
+}
+
+func (s *S3) Stat(path string) (fs.FileInfo, bool, error) {
+	names, err := backend.Split("stat", path)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := s.stat(context.Background(), names)
+	if err != nil || info == nil {
+		return nil, false, err
+	}
+	return info, true, nil
+}
+
+// Rename copies objects to new keys and deletes old ones, directory is moved
+// object by object and is not atomic.
+func (s *S3) Rename(oldpath, newpath string) error {
+	ctx := context.Background()
+	plan, err := s.planCopy(ctx, "rename", oldpath, newpath)
+	if err != nil {
+		return err
+	}
+	for _, c := range plan {
+		if err := s.copy(ctx, c, nil); err != nil {
+			return err
+		}
+	}
+	for _, c := range plan {
+		if err := s.deleteObject(ctx, c.oldKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy copies objects on server side, modification times are not kept.
+func (s *S3) Copy(oldpath, newpath string, progress func(size int64) error) error {
+	ctx := context.Background()
+	plan, err := s.planCopy(ctx, "copy", oldpath, newpath)
+	if err != nil {
+		return err
+	}
+	for _, c := range plan {
+		if err := s.copy(ctx, c, nil); err != nil {
+			return err
+		}
+		if c.marker || progress == nil {
+			continue
+		}
+		if err := progress(c.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copy object of plan, objects bigger than copyPartSize are copied part by
+// part. Metadata of source is kept if metadata is nil.
+func (s *S3) copy(ctx context.Context, c objectCopy, metadata map[string]string) error {
+	partSize := s.copyPartSize()
+	if c.size <= partSize {
+		return s.copyObject(ctx, c.oldKey, c.newKey, metadata)
+	}
+	if metadata == nil {
+		// parts don't carry metadata of source
+		var err error
+		if _, _, metadata, err = s.headObject(ctx, c.oldKey); err != nil {
+			return err
+		}
+	}
+	uploadID, err := s.createMultipartUpload(ctx, c.newKey, metadata)
+	if err != nil {
+		return err
+	}
+	parts := make([]completedPart, 0, (c.size+partSize-1)/partSize)
+	for offset := int64(0); offset < c.size; offset += partSize {
+		length := partSize
+		if offset+length > c.size {
+			length = c.size - offset
+		}
+		number := len(parts) + 1
+		etag, err := s.uploadPartCopy(ctx, c.oldKey, c.newKey, uploadID, number, offset, length)
+		if err != nil {
+			s.abortMultipartUpload(ctx, c.newKey, uploadID)
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: etag})
+	}
+	if err := s.completeMultipartUpload(ctx, c.newKey, uploadID, parts); err != nil {
+		s.abortMultipartUpload(ctx, c.newKey, uploadID)
+		return err
+	}
+	return nil
+}
+
+// SetDigest stores digest as user metadata of object, which is kept by
+// Rename and Copy. Metadata can't be changed in place, so object is copied
+// onto itself.
+func (s *S3) SetDigest(path, algorithm, digest string) error {
+	names, err := backend.Split("setdigest", path)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	key := s.key(names)
+	size, _, metadata, err := s.headObject(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.notFile(ctx, "setdigest", path, names)
+	}
+	if err != nil {
+		return err
+	}
+	if metadata[algorithm] == digest {
+		return nil
+	}
+	metadata[algorithm] = digest
+	return s.copy(ctx, objectCopy{oldKey: key, newKey: key, size: size}, metadata)
+}
+
+func (s *S3) Digest(path, algorithm string) (string, error) {
+	names, err := backend.Split("digest", path)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	_, _, metadata, err := s.headObject(ctx, s.key(names))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", s.notFile(ctx, "digest", path, names)
+	}
+	if err != nil {
+		return "", err
+	}
+	return metadata[algorithm], nil
+}
+
+// error for path without object, directory is EISDIR
+func (s *S3) notFile(ctx context.Context, op, path string, names []string) error {
+	info, err := s.stat(ctx, names)
+	if err != nil {
+		return err
+	}
+	if info != nil && info.IsDir() {
+		return &fs.PathError{Op: op, Path: path, Err: syscall.EISDIR}
+	}
+	return &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+}
+
+type objectCopy struct {
+	oldKey, newKey string
+	size           int64
+	// directory marker
+	marker bool
+}
+
+// check paths of Rename and Copy and list objects to copy, plan is empty
+// when both paths are the same
+func (s *S3) planCopy(ctx context.Context, op, oldpath, newpath string) ([]objectCopy, error) {
+	oldNames, newNames, err := backend.SplitRename(oldpath, newpath)
+	if err != nil {
+		return nil, err
+	}
+	if s.key(oldNames) == s.key(newNames) {
+		return nil, nil
+	}
+	oldInfo, err := s.stat(ctx, oldNames)
+	if err != nil {
+		return nil, err
+	}
+	if oldInfo == nil {
+		return nil, &fs.PathError{Op: op, Path: oldpath, Err: fs.ErrNotExist}
+	}
+	if err := s.checkParent(ctx, op, newpath, newNames); err != nil {
+		return nil, err
+	}
+	newInfo, err := s.stat(ctx, newNames)
+	if err != nil {
+		return nil, err
+	}
+	if newInfo != nil && (oldInfo.IsDir() || newInfo.IsDir()) {
+		return nil, &fs.PathError{Op: op, Path: newpath, Err: fs.ErrExist}
+	}
+
+	if !oldInfo.IsDir() {
+		return []objectCopy{{oldKey: s.key(oldNames), newKey: s.key(newNames), size: oldInfo.Size()}}, nil
+	}
+
+	// collect whole subtree first, copies must not show up in listing
+	oldPrefix, newPrefix := s.dirKey(oldNames), s.dirKey(newNames)
+	plan := make([]objectCopy, 0)
+	token := ""
+	for {
+		result, err := s.listObjects(ctx, oldPrefix, "", token, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			plan = append(plan, objectCopy{
+				oldKey: object.Key,
+				newKey: newPrefix + strings.TrimPrefix(object.Key, oldPrefix),
+				size:   object.Size,
+				marker: strings.HasSuffix(object.Key, "/"),
+			})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return plan, nil
+}
+
+type writer struct {
+	s   *S3
+	ctx context.Context
+	key string
+
+	buf      bytes.Buffer
+	uploadID string
+	parts    []completedPart
+	err      error
+	closed   bool
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(b)
+	for w.buf.Len() >= w.s.partSize() {
+		if err := w.flushPart(w.buf.Next(w.s.partSize())); err != nil {
+			w.abort(err)
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *writer) flushPart(data []byte) error {
+	if w.uploadID == "" {
+		uploadID, err := w.s.createMultipartUpload(w.ctx, w.key, nil)
+		if err != nil {
+			return err
+		}
+		w.uploadID = uploadID
+	}
+	number := len(w.parts) + 1
+	etag, err := w.s.uploadPart(w.ctx, w.key, w.uploadID, number, data)
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, completedPart{PartNumber: number, ETag: etag})
+	return nil
+}
+
+// remember error and drop started multipart upload
+func (w *writer) abort(err error) {
+	w.err = err
+	if w.uploadID != "" {
+		w.s.abortMultipartUpload(w.ctx, w.key, w.uploadID)
+		w.uploadID = ""
+	}
+}
+
+func (w *writer) Commit() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.uploadID == "" {
+		return w.s.putObject(w.ctx, w.key, w.buf.Bytes())
+	}
+	if w.buf.Len() > 0 {
+		if err := w.flushPart(w.buf.Bytes()); err != nil {
+			w.abort(err)
+			return err
+		}
+	}
+	if err := w.s.completeMultipartUpload(w.ctx, w.key, w.uploadID, w.parts); err != nil {
+		w.abort(err)
+		return err
+	}
+	return nil
+}
+
+func (w *writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.buf.Reset()
+	if w.uploadID != "" {
+		err := w.s.abortMultipartUpload(w.ctx, w.key, w.uploadID)
+		w.uploadID = ""
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+	"github.com/muskelo/ns_server/storage/internal/backend/backendtest"
+)
+
+const (
+	testBucket    = "ns"
+	testRegion    = "us-east-1"
+	testAccessKey = "access"
+	testSecretKey = "secret"
+)
+
+// fakeS3 is minimal in-memory stand-in of S3 API, enough for the backend
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modTimes map[string]time.Time
+	metadata map[string]http.Header
+	uploads  map[string]map[int][]byte
+	// metadata of multipart uploads
+	uploadMetadata map[string]http.Header
+	completed      int
+	// parts copied with UploadPartCopy
+	copiedParts int
+	nextID      int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects:        make(map[string][]byte),
+		modTimes:       make(map[string]time.Time),
+		metadata:       make(map[string]http.Header),
+		uploads:        make(map[string]map[int][]byte),
+		uploadMetadata: make(map[string]http.Header),
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, s3Code string) {
+	w.WriteHeader(code)
+	xml.NewEncoder(w).Encode(&Error{Code: s3Code, Message: s3Code})
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// check signature by signing copy of request again, sign itself is
+	// checked against AWS examples by TestSign
+	check := r.Clone(context.Background())
+	check.URL.Host = r.Host
+	date, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	sign(check, testRegion, testAccessKey, testSecretKey, date)
+	if check.Header.Get("Authorization") != r.Header.Get("Authorization") {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, query)
+	case query.Has("uploads") && r.Method == http.MethodPost:
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = make(map[int][]byte)
+		f.uploadMetadata[id] = userMetadata(r.Header)
+		xml.NewEncoder(w).Encode(&initiateMultipartResult{UploadID: id})
+	case query.Has("uploadId"):
+		f.multipart(w, r, key, query, body)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		source = strings.TrimPrefix(source, "/"+testBucket+"/")
+		data, ok := f.objects[source]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		metadata := f.metadata[source]
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			metadata = userMetadata(r.Header)
+		} else if source == key {
+			writeError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		f.objects[key] = data
+		f.modTimes[key] = time.Now()
+		f.metadata[key] = metadata
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		f.modTimes[key] = time.Now()
+		f.metadata[key] = userMetadata(r.Header)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for name, values := range f.metadata[key] {
+			w.Header()[name] = values
+		}
+		// handles Range too
+		http.ServeContent(w, r, "", f.modTimes[key], bytes.NewReader(data))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		delete(f.metadata, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) multipart(w http.ResponseWriter, r *http.Request, key string, query map[string][]string, body []byte) {
+	id := query["uploadId"][0]
+	parts, ok := f.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		number, _ := strconv.Atoi(query["partNumber"][0])
+		etag := fmt.Sprintf(`"%v-%v"`, id, number)
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			source, _ = url.PathUnescape(source)
+			data, ok := f.objects[strings.TrimPrefix(source, "/"+testBucket+"/")]
+			var first, last int
+			_, err := fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &first, &last)
+			if !ok || err != nil || last >= len(data) || first > last {
+				writeError(w, http.StatusBadRequest, "InvalidRequest")
+				return
+			}
+			parts[number] = data[first : last+1]
+			f.copiedParts++
+			fmt.Fprintf(w, "<CopyPartResult><ETag>%v</ETag></CopyPartResult>", etag)
+			return
+		}
+		parts[number] = body
+		w.Header().Set("ETag", etag)
+	case http.MethodPost:
+		complete := &completeMultipartUpload{}
+		if err := xml.Unmarshal(body, complete); err != nil {
+			writeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var data []byte
+		for _, part := range complete.Parts {
+			data = append(data, parts[part.PartNumber]...)
+		}
+		f.objects[key] = data
+		f.modTimes[key] = time.Now()
+		f.metadata[key] = f.uploadMetadata[id]
+		delete(f.uploads, id)
+		delete(f.uploadMetadata, id)
+		f.completed++
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case http.MethodDelete:
+		delete(f.uploads, id)
+		delete(f.uploadMetadata, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// x-amz-meta-* headers of request
+func userMetadata(header http.Header) http.Header {
+	metadata := make(http.Header)
+	for name, values := range header {
+		if strings.HasPrefix(name, metaPrefix) {
+			metadata[name] = values
+		}
+	}
+	return metadata
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query map[string][]string) {
+	get := func(key string) string {
+		if len(query[key]) == 0 {
+			return ""
+		}
+		return query[key][0]
+	}
+	prefix, delimiter, token := get("prefix"), get("delimiter"), get("continuation-token")
+	maxKeys := 1000
+	if v := get("max-keys"); v != "" {
+		maxKeys, _ = strconv.Atoi(v)
+	}
+
+	// keys and common prefixes in one sorted list, prefixes end with delimiter
+	entries := make([]string, 0)
+	seen := make(map[string]bool)
+	for key := range f.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		entry := key
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	sort.Strings(entries)
+
+	result := &listResult{}
+	for _, entry := range entries {
+		if token != "" && entry <= token {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.NextContinuationToken = entry
+		_, isObject := f.objects[entry]
+		if isObject && (delimiter == "" || !strings.HasSuffix(entry[len(prefix):], delimiter) || entry == prefix) {
+			result.Contents = append(result.Contents, object{Key: entry, Size: int64(len(f.objects[entry])), LastModified: f.modTimes[entry]})
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, struct {
+				Prefix string `xml:"Prefix"`
+			}{entry})
+		}
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		*listResult
+	}{listResult: result})
+}
+
+// return backend on fake server, or on real one from NS_TEST_S3_* environment
+func newTestS3(t *testing.T) (*S3, *fakeS3) {
+	t.Helper()
+	if endpoint := os.Getenv("NS_TEST_S3_ENDPOINT"); endpoint != "" {
+		return &S3{
+			Endpoint:  endpoint,
+			Region:    os.Getenv("NS_TEST_S3_REGION"),
+			Bucket:    os.Getenv("NS_TEST_S3_BUCKET"),
+			AccessKey: os.Getenv("NS_TEST_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("NS_TEST_S3_SECRET_KEY"),
+			Prefix:    fmt.Sprintf("test-%v/", time.Now().UnixNano()),
+		}, nil
+	}
+
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return &S3{
+		Endpoint:  server.URL,
+		Region:    testRegion,
+		Bucket:    testBucket,
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+		Prefix:    "root",
+		// small parts to exercise multipart upload
+		PartSize: 1024,
+	}, fake
+}
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		s, _ := newTestS3(t)
+		return s
+	})
+}
+
+func TestMultipartUpload(t *testing.T) {
+	s, fake := newTestS3(t)
+	if fake == nil {
+		t.Skip("needs fake server")
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 200)
+	file, err := s.Create("/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(file, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.completed != 1 {
+		t.Errorf("completed multipart uploads = %v, want 1", fake.completed)
+	}
+	if got := fake.objects["root/big.bin"]; !bytes.Equal(got, data) {
+		t.Errorf("stored %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestMultipartCopy(t *testing.T) {
+	s, fake := newTestS3(t)
+	if fake == nil {
+		t.Skip("needs fake server")
+	}
+	s.CopyPartSize = 1000
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 200)
+	fake.objects["root/big.bin"] = data
+	fake.modTimes["root/big.bin"] = time.Now()
+	if err := s.SetDigest("/big.bin", "sha256", "aaaa"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Copy("/big.bin", "/copy.bin", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rename("/copy.bin", "/moved.bin"); err != nil {
+		t.Fatal(err)
+	}
+	// SetDigest copies object onto itself too
+	if fake.copiedParts != 12 {
+		t.Errorf("copied parts = %v, want 12", fake.copiedParts)
+	}
+	if digest, err := s.Digest("/moved.bin", "sha256"); err != nil || digest != "aaaa" {
+		t.Errorf("Digest of moved object = %q, %v, want %q", digest, err, "aaaa")
+	}
+	if got := fake.objects["root/moved.bin"]; !bytes.Equal(got, data) {
+		t.Errorf("copied %d bytes, want %d", len(got), len(data))
+	}
+	if _, ok := fake.objects["root/copy.bin"]; ok {
+		t.Error("renamed object is not removed")
+	}
+
+	// small objects are copied with single request
+	fake.objects["root/small.bin"] = data[:1000]
+	if err := s.Copy("/small.bin", "/small2.bin", nil); err != nil {
+		t.Fatal(err)
+	}
+	if fake.copiedParts != 12 {
+		t.Errorf("small object is copied with %v parts", fake.copiedParts-12)
+	}
+}
+
+func TestImplicitDirectory(t *testing.T) {
+	s, fake := newTestS3(t)
+	if fake == nil {
+		t.Skip("needs fake server")
+	}
+	// object written by other tool, without directory markers
+	fake.objects["root/a/b/c.txt"] = []byte("c")
+	fake.modTimes["root/a/b/c.txt"] = time.Now()
+
+	info, exist, err := s.Stat("/a")
+	if err != nil || !exist || !info.IsDir() {
+		t.Fatalf("Stat(a) = %v, %v, %v", info, exist, err)
+	}
+	files, dirs, err := s.ReadDir("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 || len(dirs) != 1 || dirs[0].Path != "/a/b" {
+		t.Errorf("ReadDir(a) = %v %v", files, dirs)
+	}
+	if err := s.Remove("/a/b"); err == nil {
+		t.Errorf("Remove(not empty dir) err = nil")
+	}
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// extensions of archive names
+var archiveExts = map[pb.ArchiveFormat]string{
+	pb.ArchiveFormat_ZIP:     ".zip",
+	pb.ArchiveFormat_TAR:     ".tar",
+	pb.ArchiveFormat_TAR_GZ:  ".tar.gz",
+	pb.ArchiveFormat_TAR_ZST: ".tar.zst",
+}
+
+// archiveWriter adds entries to archive, name is slash separated and ends
+// with slash for directory
+type archiveWriter interface {
+	add(name string, info fs.FileInfo, content io.Reader) error
+	Close() error
+}
+
+type tarWriter struct {
+	*tar.Writer
+}
+
+func (w tarWriter) add(name string, info fs.FileInfo, content io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		ModTime: info.ModTime(),
+		Format:  tar.FormatPAX,
+	}
+	if info.IsDir() {
+		header.Typeflag = tar.TypeDir
+	} else {
+		header.Typeflag = tar.TypeReg
+		header.Size = info.Size()
+	}
+	if err := w.WriteHeader(header); err != nil {
+		return err
+	}
+	if content == nil {
+		return nil
+	}
+	// file can't grow in tar, so fail if it is changed while reading
+	_, err := io.CopyN(w, content, info.Size())
+	return err
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (w zipWriter) add(name string, info fs.FileInfo, content io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Modified: info.ModTime(),
+		Method:   zip.Deflate,
+	}
+	if info.IsDir() {
+		header.Method = zip.Store
+	}
+	header.SetMode(info.Mode())
+	entry, err := w.CreateHeader(header)
+	if err != nil || content == nil {
+		return err
+	}
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+// compressor is closed after tar, so tar footer is compressed too
+type compressedTarWriter struct {
+	tarWriter
+	compressor io.WriteCloser
+}
+
+func (w compressedTarWriter) Close() error {
+	if err := w.tarWriter.Close(); err != nil {
+		return err
+	}
+	return w.compressor.Close()
+}
+
+func newArchiveWriter(format pb.ArchiveFormat, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case pb.ArchiveFormat_ZIP:
+		return zipWriter{zip.NewWriter(w)}, nil
+	case pb.ArchiveFormat_TAR_GZ:
+		gz := gzip.NewWriter(w)
+		return compressedTarWriter{tarWriter{tar.NewWriter(gz)}, gz}, nil
+	case pb.ArchiveFormat_TAR_ZST:
+		zst, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return compressedTarWriter{tarWriter{tar.NewWriter(zst)}, zst}, nil
+	}
+	return tarWriter{tar.NewWriter(w)}, nil
+}
+
+// downloadArchive streams directory as archive while walking it, entries are
+// under directory name and in byte order of names. Symlinks to files are
+// stored as files, symlinks to directories are skipped.
+func (s *Server) downloadArchive(dir string, info fs.FileInfo, request *pb.DownloadRequest, stream pb.StorageService_DownloadServer) error {
+	ext, ok := archiveExts[request.Archive]
+	if !ok {
+		return status.Error(codes.InvalidArgument, "unknown archive format")
+	}
+	if request.Offset != 0 || request.Length != 0 || request.Revision != "" {
+		return status.Error(codes.InvalidArgument, "archive can't have offset, length or revision")
+	}
+	if !info.IsDir() {
+		return status.Errorf(codes.FailedPrecondition, "%v is not a directory", dir)
+	}
+
+	name := path.Base(path.Join("/", dir))
+	if name == "/" {
+		name = "root"
+	}
+	md := metadata.Pairs(
+		"name", name+ext,
+		"mod_time", strconv.FormatInt(info.ModTime().UnixMilli(), 10),
+	)
+	if err := stream.SendHeader(md); err != nil {
+		return err
+	}
+
+	streamWriter := new(pb.StreamWriter)
+	streamWriter.StorageService_DownloadServer(stream)
+	// headers of entries are small, don't send them as separate messages
+	buffered := bufio.NewWriterSize(streamWriter, 32*1024)
+	w, err := newArchiveWriter(request.Archive, buffered)
+	if err != nil {
+		return err
+	}
+	if err := w.add(name+"/", info, nil); err != nil {
+		return err
+	}
+	if err := s.archiveDir(w, stream, dir, name+"/"); err != nil {
+		if stream.Context().Err() != nil {
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// add children of dir under prefix
+func (s *Server) archiveDir(w archiveWriter, stream pb.StorageService_DownloadServer, dir, prefix string) error {
+	files, dirs, err := s.Backend.ReadDir(dir)
+	if err != nil {
+		return statusError(err)
+	}
+	entries := make([]walkEntry, 0, len(files)+len(dirs))
+	for _, d := range dirs {
+		entries = append(entries, walkEntry{name: d.Name, path: d.Path, dir: true})
+	}
+	for _, f := range files {
+		entries = append(entries, walkEntry{name: f.Name, path: f.Path})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	for _, entry := range entries {
+		if err := stream.Context().Err(); err != nil {
+			return err
+		}
+		info, exist, err := s.Backend.Stat(entry.path)
+		if err != nil {
+			return statusError(err)
+		}
+		if !exist {
+			// removed while walking, or broken symlink
+			continue
+		}
+		if !entry.dir && !info.Mode().IsRegular() {
+			// symlink to directory isn't followed, so links can't loop;
+			// special files can't be read
+			continue
+		}
+		if entry.dir {
+			if err := w.add(prefix+entry.name+"/", info, nil); err != nil {
+				return err
+			}
+			if err := s.archiveDir(w, stream, entry.path, prefix+entry.name+"/"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.archiveFile(w, entry.path, prefix+entry.name, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) archiveFile(w archiveWriter, file, name string, info fs.FileInfo) error {
+	content, err := s.Backend.Open(file)
+	if err != nil {
+		return statusError(err)
+	}
+	defer content.Close()
+	return w.add(name, info, content)
+}
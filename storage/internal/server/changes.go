@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+	"github.com/muskelo/ns_server/storage/internal/backend"
+	"github.com/muskelo/ns_server/storage/internal/journal"
+)
+
+// ListChanges lists journal after cursor, expired cursor gets reset with
+// current cursor
+func (s *Server) ListChanges(ctx context.Context, request *pb.ListChangesRequest) (*pb.ListChangesResponse, error) {
+	if s.Journal == nil {
+		return nil, status.Error(codes.Unimplemented, "journal is disabled")
+	}
+	if request.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid limit")
+	}
+	dir := request.Path
+	if dir == "" {
+		dir = "/"
+	}
+	if _, err := backend.Split("listchanges", dir); err != nil {
+		return nil, statusError(err)
+	}
+	if request.Cursor == "" {
+		return &pb.ListChangesResponse{Cursor: s.Journal.Cursor()}, nil
+	}
+
+	entries, cursor, more, err := s.Journal.List(request.Cursor, dir, int(request.Limit))
+	switch {
+	case errors.Is(err, journal.ErrExpired):
+		return &pb.ListChangesResponse{Cursor: s.Journal.Cursor(), Reset_: true}, nil
+	case errors.Is(err, journal.ErrInvalidCursor):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		return nil, err
+	}
+	response := &pb.ListChangesResponse{Cursor: cursor, HasMore: more}
+	for _, entry := range entries {
+		response.Changes = append(response.Changes, newWatchEvent(entry.Change, entry.Time))
+	}
+	return response, nil
+}
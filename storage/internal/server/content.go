@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// maxSnippet is max length of snippet in bytes
+const maxSnippet = 200
+
+// SearchContent returns lines of indexed files under path containing all
+// words of query. Snippets are read from current content of files.
+func (s *Server) SearchContent(ctx context.Context, request *pb.SearchContentRequest) (*pb.SearchContentResponse, error) {
+	if s.Index == nil {
+		return nil, status.Error(codes.Unimplemented, "content index is disabled")
+	}
+	if strings.TrimSpace(request.Query) == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing query")
+	}
+	if request.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid limit")
+	}
+	if _, err := s.checkWalkRoot(request.Path); err != nil {
+		return nil, err
+	}
+
+	response := &pb.SearchContentResponse{}
+	hits := s.Index.Search(request.Query, request.Path, int(request.Limit))
+	for len(hits) > 0 {
+		// hits are ordered by path, read every file once
+		n := 1
+		for n < len(hits) && hits[n].Path == hits[0].Path {
+			n++
+		}
+		lines := make([]int, n)
+		for i := range lines {
+			lines[i] = hits[i].Line
+		}
+		snippets, err := s.readLines(hits[0].Path, lines)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		for i, snippet := range snippets {
+			response.Hits = append(response.Hits, &pb.SearchContentResponse_Hit{
+				Path:    hits[i].Path,
+				Line:    int32(hits[i].Line),
+				Snippet: snippet,
+			})
+		}
+		hits = hits[n:]
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+	}
+	return response, nil
+}
+
+// read shortened lines of file by sorted numbers, lines past end of file
+// are empty, file removed after indexing has no lines
+func (s *Server) readLines(path string, numbers []int) ([]string, error) {
+	_, exist, err := s.Backend.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	file, err := s.Backend.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	snippets := make([]string, len(numbers))
+	reader := bufio.NewReader(file)
+	line := 0
+	for i, number := range numbers {
+		for line < number {
+			text, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			line++
+			if line == number {
+				snippets[i] = snippet(text)
+			}
+			if err == io.EOF {
+				return snippets, nil
+			}
+		}
+	}
+	return snippets, nil
+}
+
+// trim line and cut it to maxSnippet bytes on rune boundary
+func snippet(line string) string {
+	line = strings.TrimSpace(line)
+	if len(line) <= maxSnippet {
+		return line
+	}
+	cut := maxSnippet
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	return line[:cut]
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+// write modes of conflict policies, SKIP checks existence before write
+var extractModes = map[pb.ExtractConflict]pb.WriteMode{
+	pb.ExtractConflict_FAIL:    pb.WriteMode_CREATE,
+	pb.ExtractConflict_SKIP:    pb.WriteMode_CREATE,
+	pb.ExtractConflict_REPLACE: pb.WriteMode_OVERWRITE,
+}
+
+// detect format by extension of name, NONE if it isn't archive
+func detectArchiveFormat(name string) pb.ArchiveFormat {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return pb.ArchiveFormat_ZIP
+	case strings.HasSuffix(name, ".tar"):
+		return pb.ArchiveFormat_TAR
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return pb.ArchiveFormat_TAR_GZ
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return pb.ArchiveFormat_TAR_ZST
+	}
+	return pb.ArchiveFormat_NONE
+}
+
+func (s *Server) Extract(ctx context.Context, request *pb.ExtractRequest) (*pb.ExtractResponse, error) {
+	if request.Path == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing path")
+	}
+	format := request.Format
+	if format == pb.ArchiveFormat_NONE {
+		format = detectArchiveFormat(request.Path)
+	}
+	if _, ok := archiveExts[format]; !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown archive format")
+	}
+	mode, ok := extractModes[request.Conflict]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown conflict policy")
+	}
+	dir := request.Dir
+	if dir == "" {
+		dir = path.Dir(path.Join("/", request.Path))
+	}
+	if _, err := backend.Split("extract", dir); err != nil {
+		return nil, statusError(err)
+	}
+
+	info, exist, err := s.Backend.Stat(request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "file %v not found", request.Path)
+	}
+	if info.IsDir() {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v is a directory", request.Path)
+	}
+	file, err := s.Backend.Open(request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	defer file.Close()
+
+	e := &extractor{
+		s:        s,
+		ctx:      ctx,
+		dir:      dir,
+		mode:     mode,
+		skip:     request.Conflict == pb.ExtractConflict_SKIP,
+		made:     make(map[string]bool),
+		response: &pb.ExtractResponse{},
+	}
+	if err := e.mkdirAll(dir); err != nil {
+		return nil, err
+	}
+	if format == pb.ArchiveFormat_ZIP {
+		err = e.zip(file, info.Size())
+	} else {
+		err = e.tar(file, format)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, err
+	}
+	return e.response, nil
+}
+
+// extractor writes entries of archive into dir
+type extractor struct {
+	s    *Server
+	ctx  context.Context
+	dir  string
+	mode pb.WriteMode
+	skip bool
+	// directories known to exist
+	made     map[string]bool
+	entries  int
+	response *pb.ExtractResponse
+}
+
+func invalidArchive(err error) error {
+	return status.Errorf(codes.InvalidArgument, "invalid archive: %v", err)
+}
+
+// zip needs random access, file is copied to temporary file if backend
+// can't read at offset. Copy is bounded by size of archive and by size
+// limit, archive larger than limit can't fit in it extracted.
+func (e *extractor) zip(file io.Reader, size int64) error {
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		if max := e.s.MaxExtractSize; max > 0 && size > max {
+			return status.Errorf(codes.ResourceExhausted, "archive is larger than %v bytes", max)
+		}
+		tmp, err := os.CreateTemp("", "ns-extract-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		// archive can grow while copied
+		if size, err = io.Copy(tmp, io.LimitReader(file, size)); err != nil {
+			return err
+		}
+		readerAt = tmp
+	}
+	archive, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return invalidArchive(err)
+	}
+	for _, f := range archive.File {
+		switch {
+		case f.Mode().IsDir():
+			err = e.add(f.Name, true, nil)
+		case f.Mode().IsRegular():
+			err = e.addZipFile(f)
+		default:
+			err = e.add(f.Name, false, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extractor) addZipFile(f *zip.File) error {
+	content, err := f.Open()
+	if err != nil {
+		return invalidArchive(err)
+	}
+	defer content.Close()
+	return e.add(f.Name, false, content)
+}
+
+func (e *extractor) tar(file io.Reader, format pb.ArchiveFormat) error {
+	switch format {
+	case pb.ArchiveFormat_TAR_GZ:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return invalidArchive(err)
+		}
+		defer gz.Close()
+		file = gz
+	case pb.ArchiveFormat_TAR_ZST:
+		zst, err := zstd.NewReader(file)
+		if err != nil {
+			return invalidArchive(err)
+		}
+		defer zst.Close()
+		file = zst
+	}
+	archive := tar.NewReader(file)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return invalidArchive(err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.add(header.Name, true, nil)
+		case tar.TypeReg:
+			err = e.add(header.Name, false, archive)
+		default:
+			err = e.add(header.Name, false, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// add entry of archive, nil content of file means unsupported entry which
+// is skipped
+func (e *extractor) add(name string, dir bool, content io.Reader) error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	e.entries++
+	if max := e.s.MaxExtractEntries; max > 0 && e.entries > max {
+		return status.Errorf(codes.ResourceExhausted, "archive has more than %v entries", max)
+	}
+	// zip made on windows can have backslash separators
+	names, err := backend.Split("extract", strings.ReplaceAll(name, `\`, "/"))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "unsafe name %q in archive", name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	target := path.Join(e.dir, strings.Join(names, "/"))
+
+	if dir {
+		return e.mkdirAll(target)
+	}
+	if content == nil {
+		e.response.Skipped++
+		return nil
+	}
+	if err := e.mkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+	if e.skip {
+		exist, err := backend.IsFileExist(e.s.Backend, target)
+		if err != nil {
+			return statusError(err)
+		}
+		if exist {
+			e.response.Skipped++
+			return nil
+		}
+	}
+	if _, err := e.s.write(target, e.mode, "", "", &extractReader{content, e}); err != nil {
+		return err
+	}
+	e.response.Files++
+	return nil
+}
+
+// create dir and missing parents, existing file fails with AlreadyExists
+func (e *extractor) mkdirAll(dir string) error {
+	dir = path.Join("/", dir)
+	if dir == "/" || e.made[dir] {
+		return nil
+	}
+	if err := e.mkdirAll(path.Dir(dir)); err != nil {
+		return err
+	}
+	info, exist, err := e.s.Backend.Stat(dir)
+	if err != nil {
+		return statusError(err)
+	}
+	if exist && !info.IsDir() {
+		return status.Errorf(codes.AlreadyExists, "file %v already exist", dir)
+	}
+	if !exist {
+		defer e.s.Events.Expect(dir)()
+		if err := e.s.Backend.Mkdir(dir); err != nil && !errors.Is(err, fs.ErrExist) {
+			return statusError(err)
+		}
+		e.response.Dirs++
+		e.s.changed(backend.Change{Op: backend.OpCreate, Path: dir, Dir: true})
+	}
+	e.made[dir] = true
+	return nil
+}
+
+// extractReader counts extracted bytes and fails when size limit is exceeded
+type extractReader struct {
+	r io.Reader
+	e *extractor
+}
+
+func (r *extractReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.e.response.Bytes += int64(n)
+	if max := r.e.s.MaxExtractSize; max > 0 && r.e.response.Bytes > max {
+		return n, status.Errorf(codes.ResourceExhausted, "archive is larger than %v bytes", max)
+	}
+	if err != nil && err != io.EOF {
+		return n, invalidArchive(err)
+	}
+	return n, err
+}
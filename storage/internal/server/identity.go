@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// Subject returns user authenticated by httpadapter, empty without one.
+// Metadata isn't verified, so storage must be reachable only by adapter.
+func Subject(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(pb.SubjectMD); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Claims returns JSON of claims of user token, nil without one
+func Claims(ctx context.Context) []byte {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(pb.ClaimsMD); len(v) > 0 {
+		return []byte(v[0])
+	}
+	return nil
+}
+
+// " by <subject>" for logs, empty without subject
+func caller(ctx context.Context) string {
+	if subject := Subject(ctx); subject != "" {
+		return " by " + subject
+	}
+	return ""
+}
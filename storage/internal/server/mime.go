@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+// bytes of content used to detect MIME type
+const sniffLen = 512
+
+// detect MIME type by first bytes of content, extension is used when content
+// says nothing more than generic binary or text
+func detectType(head []byte, name string) string {
+	detected := http.DetectContentType(head)
+	if strings.HasPrefix(detected, "application/octet-stream") || strings.HasPrefix(detected, "text/plain") {
+		if byExt := mime.TypeByExtension(path.Ext(name)); byExt != "" {
+			return byExt
+		}
+	}
+	return detected
+}
+
+func detectMIME(b backend.Backend, name string) (string, error) {
+	file, err := b.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return detectType(head[:n], name), nil
+}
+
+// storedType returns MIME type stored on upload, or type by extension of
+// file written before types were stored. Content isn't read, so it's cheap
+// enough for every entry of listing.
+func (s *Server) storedType(name string) string {
+	if digester, ok := s.Backend.(backend.Digester); ok {
+		if stored, err := digester.Digest(name, "mime"); err == nil && stored != "" {
+			return stored
+		}
+	}
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// mimeType returns stored MIME type of file, type of file written before it
+// was stored is detected from content. Type is best effort, file that can't
+// be read gets type by extension.
+func (s *Server) mimeType(name string) string {
+	if digester, ok := s.Backend.(backend.Digester); ok {
+		if stored, err := digester.Digest(name, "mime"); err == nil && stored != "" {
+			return stored
+		}
+	}
+	detected, err := detectMIME(s.Backend, name)
+	if err != nil {
+		return mime.TypeByExtension(path.Ext(name))
+	}
+	return detected
+}
+
+// store MIME type of file, failure only makes it detected again
+func (s *Server) setMIMEType(path, mimeType string) {
+	digester, ok := s.Backend.(backend.Digester)
+	if !ok {
+		return
+	}
+	// file system without extended attributes, don't log it on every upload
+	if err := digester.SetDigest(path, "mime", mimeType); err != nil && !errors.Is(err, syscall.ENOTSUP) {
+		log.Printf("set mime type of %v: %v", path, err)
+	}
+}
+
+// sniffer keeps first bytes written to it
+type sniffer struct {
+	head []byte
+}
+
+func (w *sniffer) Write(b []byte) (int, error) {
+	if rest := sniffLen - len(w.head); rest > 0 {
+		if len(b) < rest {
+			rest = len(b)
+		}
+		w.head = append(w.head, b[:rest]...)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+type dirEntry struct {
+	name string
+	path string
+	dir  bool
+	// nil until stat
+	info fs.FileInfo
+}
+
+// at most so many directories of response get child counts, each count lists
+// the directory
+const maxCountedDirs = 100
+
+// ReadDir lists directory page by page, directories go before files. Only
+// entries of the page are stat'ed, unless sort needs all of them. Page token
+// is sort key of last entry, next page starts after it, so entries created or
+// removed meanwhile don't shift pages.
+func (s *Server) ReadDir(ctx context.Context, request *pb.ReadDirRequest) (*pb.ReadDirResponse, error) {
+	exist, err := backend.IsDirExist(s.Backend, request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Directory %v not exist", request.GetPath())
+	}
+	var after *dirEntry
+	if request.PageToken != "" {
+		after, err = parsePageToken(request.PageToken)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+	}
+	if request.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid page size")
+	}
+
+	files, dirs, err := s.Backend.ReadDir(request.GetPath())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	entries := make([]*dirEntry, 0, len(files)+len(dirs))
+	for _, dir := range dirs {
+		entries = append(entries, &dirEntry{name: dir.Name, path: dir.Path, dir: true})
+	}
+	for _, file := range files {
+		entries = append(entries, &dirEntry{name: file.Name, path: file.Path})
+	}
+	if request.Filter != "" {
+		entries, err = filterEntries(entries, request.Filter)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid filter: %v", err)
+		}
+	}
+
+	if request.Sort != pb.ReadDirRequest_NAME {
+		if entries, err = s.statEntries(entries); err != nil {
+			return nil, statusError(err)
+		}
+	}
+	less := func(a, b *dirEntry) bool {
+		return lessEntry(a, b, request.Sort, request.Descending)
+	}
+	if after != nil {
+		rest := entries[:0]
+		for _, entry := range entries {
+			if less(after, entry) {
+				rest = append(rest, entry)
+			}
+		}
+		entries = rest
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+
+	response := &pb.ReadDirResponse{
+		Files: make([]*pb.ReadDirResponse_File, 0),
+		Dirs:  make([]*pb.ReadDirResponse_Dir, 0),
+	}
+	page := entries
+	if request.PageSize > 0 && len(page) > int(request.PageSize) {
+		page = page[:request.PageSize]
+		response.NextPageToken = pageToken(page[len(page)-1], request.Sort)
+	}
+	if page, err = s.statEntries(page); err != nil {
+		return nil, statusError(err)
+	}
+
+	counted := 0
+	for _, entry := range page {
+		if !entry.dir {
+			response.Files = append(response.Files, &pb.ReadDirResponse_File{
+				Name:     entry.name,
+				Path:     entry.path,
+				Size:     entry.info.Size(),
+				ModTime:  entry.info.ModTime().UnixMilli(),
+				MimeType: s.storedType(entry.path),
+			})
+			continue
+		}
+		dir := &pb.ReadDirResponse_Dir{
+			Name:    entry.name,
+			Path:    entry.path,
+			ModTime: entry.info.ModTime().UnixMilli(),
+			Files:   -1,
+			Dirs:    -1,
+		}
+		if counted < maxCountedDirs {
+			counted++
+			// directory can be unreadable or removed meanwhile
+			if files, dirs, err := s.Backend.ReadDir(entry.path); err == nil {
+				dir.Files, dir.Dirs = int64(len(files)), int64(len(dirs))
+			}
+		}
+		response.Dirs = append(response.Dirs, dir)
+	}
+	return response, nil
+}
+
+// token of page after entry, sort key is empty for NAME
+func pageToken(entry *dirEntry, order pb.ReadDirRequest_Sort) string {
+	kind := "f"
+	if entry.dir {
+		kind = "d"
+	}
+	key := ""
+	switch order {
+	case pb.ReadDirRequest_SIZE:
+		key = strconv.FormatInt(entry.info.Size(), 10)
+	case pb.ReadDirRequest_MOD_TIME:
+		key = strconv.FormatInt(entry.info.ModTime().UnixNano(), 10)
+	}
+	return kind + ":" + key + ":" + entry.name
+}
+
+// entry of page token, only its sort keys are set
+func parsePageToken(token string) (*dirEntry, error) {
+	parts := strings.SplitN(token, ":", 3)
+	if len(parts) != 3 || (parts[0] != "d" && parts[0] != "f") || parts[2] == "" {
+		return nil, errors.New("invalid page token")
+	}
+	key := int64(0)
+	if parts[1] != "" {
+		var err error
+		if key, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	dir := parts[0] == "d"
+	return &dirEntry{
+		name: parts[2],
+		dir:  dir,
+		info: backend.NewFileInfo(parts[2], key, dir, time.Unix(0, key)),
+	}, nil
+}
+
+// keep entries with name matching glob pattern
+func filterEntries(entries []*dirEntry, pattern string) ([]*dirEntry, error) {
+	pattern = strings.ToLower(pattern)
+	filtered := entries[:0]
+	for _, entry := range entries {
+		ok, err := path.Match(pattern, strings.ToLower(entry.name))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered, nil
+}
+
+// stat entries without info, entries removed since listing are dropped
+func (s *Server) statEntries(entries []*dirEntry) ([]*dirEntry, error) {
+	existing := entries[:0]
+	for _, entry := range entries {
+		if entry.info == nil {
+			info, exist, err := s.Backend.Stat(entry.path)
+			if err != nil {
+				return nil, err
+			}
+			if !exist {
+				continue
+			}
+			entry.info = info
+		}
+		existing = append(existing, entry)
+	}
+	return existing, nil
+}
+
+func lessEntry(a, b *dirEntry, order pb.ReadDirRequest_Sort, descending bool) bool {
+	if a.dir != b.dir {
+		return a.dir
+	}
+	if descending {
+		a, b = b, a
+	}
+	switch {
+	case order == pb.ReadDirRequest_SIZE && !a.dir && a.info.Size() != b.info.Size():
+		return a.info.Size() < b.info.Size()
+	case order == pb.ReadDirRequest_MOD_TIME && !a.info.ModTime().Equal(b.info.ModTime()):
+		return a.info.ModTime().Before(b.info.ModTime())
+	}
+	return naturalLess(a.name, b.name)
+}
+
+// naturalLess compares names case-insensitively with digit runs compared as
+// numbers, so "file2" goes before "File10"
+func naturalLess(a, b string) bool {
+	if c := naturalCompare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			numA, restA := splitDigits(a)
+			numB, restB := splitDigits(b)
+			// longer number without leading zeros is bigger
+			trimA, trimB := strings.TrimLeft(numA, "0"), strings.TrimLeft(numB, "0")
+			if len(trimA) != len(trimB) {
+				return len(trimA) - len(trimB)
+			}
+			if c := strings.Compare(trimA, trimB); c != 0 {
+				return c
+			}
+			if len(numA) != len(numB) {
+				return len(numA) - len(numB)
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			return int(a[0]) - int(b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) - len(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// split leading digits of s
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
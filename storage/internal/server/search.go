@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+)
+
+// Search walks subtree and sends entries matching name, type, size and
+// modification time filters.
+func (s *Server) Search(request *pb.SearchRequest, stream pb.StorageService_SearchServer) error {
+	if _, err := s.checkWalkRoot(request.Path); err != nil {
+		return err
+	}
+	if request.Limit < 0 || request.MinSize < 0 || request.MaxSize < 0 {
+		return status.Error(codes.InvalidArgument, "invalid limit or size")
+	}
+	glob := strings.ToLower(request.Glob)
+	if _, err := path.Match(glob, ""); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid glob %q", request.Glob)
+	}
+	var re *regexp.Regexp
+	if request.Regexp != "" {
+		var err error
+		re, err = regexp.Compile(request.Regexp)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid regexp: %v", err)
+		}
+	}
+
+	w := &walker{s: s, stream: stream, limit: int(request.Limit)}
+	w.match = func(entry walkEntry, relPath string) bool {
+		switch {
+		case request.Type == pb.SearchRequest_FILE && entry.dir,
+			request.Type == pb.SearchRequest_DIR && !entry.dir:
+			return false
+		case glob != "":
+			if ok, _ := path.Match(glob, strings.ToLower(entry.name)); !ok {
+				return false
+			}
+		}
+		return re == nil || re.MatchString(entry.name)
+	}
+	w.matchInfo = func(info fs.FileInfo) bool {
+		modTime := info.ModTime().UnixMilli()
+		switch {
+		case !info.IsDir() && info.Size() < request.MinSize,
+			!info.IsDir() && request.MaxSize > 0 && info.Size() > request.MaxSize,
+			request.ModifiedAfter > 0 && modTime <= request.ModifiedAfter,
+			request.ModifiedBefore > 0 && modTime >= request.ModifiedBefore:
+			return false
+		}
+		return true
+	}
+	return w.run(request.Path)
+}
@@ -3,10 +3,19 @@ package server
 import (
 	// buildin
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
+	"io/fs"
 	"log"
 	"net"
+	"path"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	// other
 	"google.golang.org/grpc"
@@ -17,11 +26,17 @@ import (
 
 	// local
 	pb "github.com/muskelo/ns_server/protos/storage"
-	"github.com/muskelo/ns_server/storage/internal/filemanager"
+	"github.com/muskelo/ns_server/storage/internal/backend"
+	"github.com/muskelo/ns_server/storage/internal/events"
+	"github.com/muskelo/ns_server/storage/internal/index"
+	"github.com/muskelo/ns_server/storage/internal/journal"
+	"github.com/muskelo/ns_server/storage/internal/thumbnail"
+	"github.com/muskelo/ns_server/storage/internal/uploads"
 )
 
-// run server with default grpc server
-func Serve(addr string, server *Server) error {
+// run server with default grpc server until ctx is done, then wait for
+// running calls
+func Serve(ctx context.Context, addr string, server *Server) error {
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
@@ -36,92 +51,416 @@ func Serve(addr string, server *Server) error {
 	)
 	pb.RegisterStorageServiceServer(s, server)
 	reflection.Register(s)
+	go func() {
+		<-ctx.Done()
+		// Watch streams don't end by themselves
+		server.Events.Close()
+		s.GracefulStop()
+	}()
 	return s.Serve(lis)
 }
 
-func New(fm *filemanager.FileManager) *Server {
+func New(b backend.Backend) *Server {
 	return &Server{
-		FM: fm,
+		Backend: b,
 	}
 }
 
 type Server struct {
-	FM *filemanager.FileManager
+	Backend backend.Backend
+	// content index, nil disables SearchContent
+	Index *index.Index
+	// staged resumable uploads, nil disables them
+	Uploads *uploads.Store
+	// cache of Thumbnail, nil disables caching
+	Thumbnails *thumbnail.Cache
+	// changes sent to Watch, nil disables it
+	Events *events.Hub
+	// changes listed by ListChanges, nil disables it
+	Journal *journal.Journal
+	// limits of Extract, 0 is unlimited
+	MaxExtractSize    int64
+	MaxExtractEntries int
+
+	// makes check and commit of upload, move and copy atomic
+	mu sync.Mutex
 }
 
 func (s *Server) Mkdir(ctx context.Context, request *pb.MkdirRequest) (*pb.MkdirResponse, error) {
-	exist, err := s.FM.IsExist(request.Path)
+	exist, err := backend.IsExist(s.Backend, request.Path)
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
 	}
 	if exist {
 		return nil, status.Errorf(codes.AlreadyExists, "Directory of file %v already exist", request.Path)
 	}
 
-	err = s.FM.Mkdir(request.Path)
-	return &pb.MkdirResponse{}, err
+	defer s.Events.Expect(request.Path)()
+	err = s.Backend.Mkdir(request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	s.changed(backend.Change{Op: backend.OpCreate, Path: request.Path, Dir: true})
+	return &pb.MkdirResponse{}, nil
+}
+
+func (s *Server) Remove(ctx context.Context, request *pb.RemoveRequest) (*pb.RemoveResponse, error) {
+	if request.Revision != "" {
+		// check and remove at once like Upload commit
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		info, exist, err := s.Backend.Stat(request.Path)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		if exist && fileRevision(info) != request.Revision {
+			return nil, status.Errorf(codes.FailedPrecondition, "%v changed", request.Path)
+		}
+	}
+
+	// handle file
+	exist, err := backend.IsFileExist(s.Backend, request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if exist {
+		defer s.Events.Expect(request.Path)()
+		if err := s.Backend.Remove(request.Path); err != nil {
+			return nil, statusError(err)
+		}
+		s.Index.Remove(request.Path)
+		s.changed(backend.Change{Op: backend.OpRemove, Path: request.Path})
+		return &pb.RemoveResponse{}, nil
+	}
+
+	// handle Directory
+	exist, err = backend.IsDirExist(s.Backend, request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if exist {
+		files, dirs, err := s.Backend.ReadDir(request.Path)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		if len(files) > 0 || len(dirs) > 0 {
+			return nil, status.Error(codes.FailedPrecondition, "Directory not empty")
+		}
+		defer s.Events.Expect(request.Path)()
+		if err := s.Backend.Remove(request.Path); err != nil {
+			return nil, statusError(err)
+		}
+		s.changed(backend.Change{Op: backend.OpRemove, Path: request.Path, Dir: true})
+		return &pb.RemoveResponse{}, nil
+	}
+
+	return nil, status.Errorf(codes.NotFound, "File or Directory %v not found", request.Path)
 }
 
-func (s *Server) ReadDir(ctx context.Context, request *pb.ReadDirRequest) (*pb.ReadDirResponse, error) {
-	exist, err := s.FM.IsDirExist(request.Path)
+// Move renames file or directory. Mode applies to existing file at dst,
+// directory is never replaced.
+func (s *Server) Move(ctx context.Context, request *pb.MoveRequest) (*pb.MoveResponse, error) {
+	if _, _, err := backend.SplitRename(request.Src, request.Dst); err != nil {
+		return nil, statusError(err)
+	}
+	if request.Mode == pb.WriteMode_OVERWRITE_IF_UNCHANGED && request.Revision == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing revision")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := s.checkCopy(request.Src, request.Dst, request.Mode, request.Revision)
 	if err != nil {
 		return nil, err
 	}
+	defer s.Events.Expect(request.Src, request.Dst)()
+	if err := s.Backend.Rename(request.Src, request.Dst); err != nil {
+		return nil, statusError(err)
+	}
+	s.Index.Rename(request.Src, request.Dst)
+	s.changed(backend.Change{Op: backend.OpMove, Path: request.Dst, OldPath: request.Src, Dir: info.IsDir()})
+	return &pb.MoveResponse{}, nil
+}
+
+// Stat returns info about file or directory. MIME type of file is best
+// effort and doesn't fail the call.
+func (s *Server) Stat(ctx context.Context, request *pb.StatRequest) (*pb.StatResponse, error) {
+	names, err := backend.Split("stat", request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	info, exist, err := s.Backend.Stat(request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	// symlink itself, also when its target doesn't exist
+	symlink := false
+	if lstater, ok := s.Backend.(backend.Lstater); ok && len(names) > 0 {
+		linkInfo, linkExist, err := lstater.Lstat(request.Path)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		symlink = linkExist && linkInfo.Mode()&fs.ModeSymlink != 0
+		if !exist && symlink {
+			info, exist = linkInfo, true
+		}
+	}
 	if !exist {
-		return nil, status.Errorf(codes.NotFound, "Directory %v not exist", request.GetPath())
+		return nil, status.Errorf(codes.NotFound, "File or Directory %v not found", request.Path)
 	}
 
-	files, dirs, err := s.FM.ReadDir(request.GetPath())
+	response := &pb.StatResponse{
+		Name:     path.Base(path.Join("/", request.Path)),
+		Path:     request.Path,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixMilli(),
+		Mode:     uint32(info.Mode().Perm()),
+		Dir:      info.IsDir(),
+		Symlink:  symlink,
+		Revision: fileRevision(info),
+	}
+	if info.Mode().IsRegular() {
+		response.MimeType = s.mimeType(request.Path)
+		response.Sha256, err = s.digest(request.Path)
+		if err != nil {
+			return nil, statusError(err)
+		}
+	}
+	return response, nil
+}
+
+// progressInterval is minimal time between progress messages of Copy
+const progressInterval = 500 * time.Millisecond
+
+// Copy copies file or directory tree. Backends implementing backend.Copier
+// copy without streaming data through the server.
+func (s *Server) Copy(request *pb.CopyRequest, stream pb.StorageService_CopyServer) error {
+	if _, _, err := backend.SplitRename(request.Src, request.Dst); err != nil {
+		return statusError(err)
+	}
+	if request.Mode == pb.WriteMode_OVERWRITE_IF_UNCHANGED && request.Revision == "" {
+		return status.Error(codes.InvalidArgument, "missing revision")
+	}
+
+	ctx := stream.Context()
+	response := &pb.CopyResponse{}
+	sent := time.Now()
+	progress := func(size int64) error {
+		response.Files++
+		response.Bytes += size
+		if time.Since(sent) >= progressInterval {
+			sent = time.Now()
+			if err := stream.Send(response); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+	copyTree := s.copyTree
+	if copier, ok := s.Backend.(backend.Copier); ok {
+		copyTree = copier.Copy
+	}
+
+	// file replaces existing one, so check and copy it at once like Upload
+	// commit; new directory can be copied without lock
+	s.mu.Lock()
+	info, err := s.checkCopy(request.Src, request.Dst, request.Mode, request.Revision)
 	if err != nil {
-		return nil, err
+		s.mu.Unlock()
+		return err
+	}
+	if info.IsDir() {
+		s.mu.Unlock()
+		defer s.Events.ExpectTree(request.Dst)()
+		err = statusError(copyTree(request.Src, request.Dst, progress))
+	} else {
+		defer s.Events.Expect(request.Dst)()
+		err = statusError(copyTree(request.Src, request.Dst, progress))
+		s.mu.Unlock()
+	}
+	// index and tell about partial copy of directory too
+	s.Index.Update(request.Dst)
+	copied := err == nil
+	if !copied && info.IsDir() {
+		copied, _ = backend.IsExist(s.Backend, request.Dst)
+	}
+	if copied {
+		s.changed(backend.Change{Op: backend.OpCreate, Path: request.Dst, Dir: info.IsDir()})
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return err
 	}
 
-	response := &pb.ReadDirResponse{
-		Files: make([]*pb.ReadDirResponse_File, 0),
-		Dirs:  make([]*pb.ReadDirResponse_Dir, 0),
+	response.Done = true
+	return stream.Send(response)
+}
+
+// check that src exist and dst can be replaced by it, caller must hold s.mu
+func (s *Server) checkCopy(src, dst string, mode pb.WriteMode, revision string) (fs.FileInfo, error) {
+	info, exist, err := s.Backend.Stat(src)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "File or Directory %v not found", src)
+	}
+	if !info.IsDir() {
+		return info, s.checkWriteMode(dst, mode, revision)
+	}
+	exist, err = backend.IsExist(s.Backend, dst)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if exist {
+		return nil, status.Errorf(codes.AlreadyExists, "%v already exist", dst)
+	}
+	return info, nil
+}
+
+// copy with backend methods when backend is not backend.Copier, on error
+// copied part is left in place
+func (s *Server) copyTree(src, dst string, progress func(size int64) error) error {
+	isDir, err := backend.IsDirExist(s.Backend, src)
+	if err != nil {
+		return err
+	}
+	if !isDir {
+		size, err := s.copyFile(src, dst)
+		if err != nil {
+			return err
+		}
+		return progress(size)
+	}
+
+	if err := s.Backend.Mkdir(dst); err != nil {
+		return err
+	}
+	files, dirs, err := s.Backend.ReadDir(src)
+	if err != nil {
+		return err
 	}
 	for _, file := range files {
-		response.Files = append(response.Files, &pb.ReadDirResponse_File{
-			Name: file.Name,
-			Path: file.Path,
-		})
+		if err := s.copyTree(file.Path, path.Join(dst, file.Name), progress); err != nil {
+			return err
+		}
 	}
 	for _, dir := range dirs {
-		response.Dirs = append(response.Dirs, &pb.ReadDirResponse_Dir{
-			Name: dir.Name,
-			Path: dir.Path,
-		})
+		if err := s.copyTree(dir.Path, path.Join(dst, dir.Name), progress); err != nil {
+			return err
+		}
 	}
-	return response, nil
+	return nil
 }
 
-func (s *Server) Remove(ctx context.Context, request *pb.RemoveRequest) (*pb.RemoveResponse, error) {
-	// handle file
-	exist, err := s.FM.IsFileExist(request.Path)
+func (s *Server) copyFile(src, dst string) (int64, error) {
+	in, err := s.Backend.Open(src)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	if exist {
-		return &pb.RemoveResponse{}, s.FM.Remove(request.Path)
+	defer in.Close()
+	out, err := s.Backend.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Abort()
+	size, err := io.Copy(out, in)
+	if err != nil {
+		return 0, err
 	}
+	return size, out.Commit()
+}
 
-	// handle Directory
-	exist, err = s.FM.IsDirExist(request.Path)
+// RemoveAll removes file or directory with all its content. On cancellation
+// already removed entries stay removed.
+func (s *Server) RemoveAll(ctx context.Context, request *pb.RemoveAllRequest) (*pb.RemoveAllResponse, error) {
+	names, err := backend.Split("removeall", request.Path)
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
 	}
-	if exist {
-		files, dirs, err := s.FM.ReadDir(request.Path)
+	if len(names) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "can't remove root")
+	}
+	exist, err := backend.IsExist(s.Backend, request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "File or Directory %v not found", request.Path)
+	}
+
+	response := &pb.RemoveAllResponse{}
+	removed := make([]backend.Change, 0)
+	defer s.Events.ExpectTree(request.Path)()
+	err = s.removeAll(ctx, request.Path, response, &removed)
+	// drop removed entries and tell about them, also after cancel
+	s.Index.Update(request.Path)
+	for _, change := range removed {
+		s.changed(change)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.Errorf(status.FromContextError(ctx.Err()).Code(),
+				"removed %v files and %v directories before cancel", response.Files, response.Dirs)
+		}
+		return nil, statusError(err)
+	}
+	return response, nil
+}
+
+// remove subtree depth-first, counting removed entries in response and
+// collecting removals of largest removed subtrees, so whole tree is single
+// change
+func (s *Server) removeAll(ctx context.Context, path string, response *pb.RemoveAllResponse, removed *[]backend.Change) error {
+	isDir, err := backend.IsDirExist(s.Backend, path)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		files, dirs, err := s.Backend.ReadDir(path)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if len(files) > 0 || len(dirs) > 0 {
-			return nil, status.Error(codes.FailedPrecondition, "Directory not empty")
+		for _, file := range files {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := s.Backend.Remove(file.Path); err != nil {
+				return err
+			}
+			response.Files++
+			*removed = append(*removed, backend.Change{Op: backend.OpRemove, Path: file.Path})
+		}
+		for _, dir := range dirs {
+			if err := s.removeAll(ctx, dir.Path, response, removed); err != nil {
+				return err
+			}
 		}
-		return &pb.RemoveResponse{}, s.FM.Remove(request.Path)
 	}
 
-	return nil, status.Errorf(codes.NotFound, "File or Directory %v not found", request.Path)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.Backend.Remove(path); err != nil {
+		return err
+	}
+	if isDir {
+		response.Dirs++
+	} else {
+		response.Files++
+	}
+	// removal of path covers entries under it
+	kept := (*removed)[:0]
+	for _, change := range *removed {
+		if !strings.HasPrefix(change.Path, path+"/") {
+			kept = append(kept, change)
+		}
+	}
+	*removed = append(kept, backend.Change{Op: backend.OpRemove, Path: path, Dir: isDir})
+	return nil
 }
 
 func (s *Server) parseDownloadMD(stream pb.StorageService_DownloadServer) (path string) {
@@ -140,23 +479,51 @@ func (s *Server) Download(request *pb.DownloadRequest, stream pb.StorageService_
 	if path == "" {
 		return status.Error(codes.InvalidArgument, "missing path")
 	}
+	if request.Offset < 0 || request.Length < 0 {
+		return status.Error(codes.InvalidArgument, "invalid offset or length")
+	}
 
-	info, exist, err := s.FM.Stat(path)
+	info, exist, err := s.Backend.Stat(path)
 	if err != nil {
-		return err
+		return statusError(err)
 	}
 	if !exist {
 		return status.Errorf(codes.NotFound, "file %v not found", path)
 	}
+	if request.Archive != pb.ArchiveFormat_NONE {
+		return s.downloadArchive(path, info, request, stream)
+	}
+	if info.IsDir() {
+		return status.Errorf(codes.FailedPrecondition, "%v is a directory", path)
+	}
+	if request.Revision != "" && request.Revision != fileRevision(info) {
+		return status.Error(codes.FailedPrecondition, "file is changed")
+	}
+	if request.Offset > info.Size() {
+		return status.Errorf(codes.OutOfRange, "offset %v is past end of file", request.Offset)
+	}
 
-	md := metadata.Pairs("name", info.Name(), "size", strconv.FormatInt(info.Size(), 10))
+	md := metadata.Pairs(
+		"name", info.Name(),
+		"size", strconv.FormatInt(info.Size(), 10),
+		"revision", fileRevision(info),
+		"mod_time", strconv.FormatInt(info.ModTime().UnixMilli(), 10),
+	)
+	md.Set("mime_type", s.mimeType(path))
+	digest, err := s.digest(path)
+	if err != nil {
+		return statusError(err)
+	}
+	if digest != "" {
+		md.Set("sha256", digest)
+	}
 	if err := stream.SendHeader(md); err != nil {
 		return err
 	}
 
-	file, err := s.FM.Open(path)
+	file, err := backend.OpenRange(s.Backend, path, request.Offset, request.Length)
 	if err != nil {
-		return err
+		return statusError(err)
 	}
 	defer file.Close()
 
@@ -166,7 +533,7 @@ func (s *Server) Download(request *pb.DownloadRequest, stream pb.StorageService_
 	return err
 }
 
-func (s *Server) parseUploadMD(stream pb.StorageService_UploadServer) (path string) {
+func (s *Server) parseUploadMD(stream pb.StorageService_UploadServer) (path string, mode pb.WriteMode, revision, digest string, err error) {
 	md, ok := metadata.FromIncomingContext(stream.Context())
 	if !ok {
 		return
@@ -175,36 +542,188 @@ func (s *Server) parseUploadMD(stream pb.StorageService_UploadServer) (path stri
 	if len(v) > 0 {
 		path = v[0]
 	}
+	v = md.Get("mode")
+	if len(v) > 0 {
+		value, ok := pb.WriteMode_value[v[0]]
+		if !ok {
+			err = status.Errorf(codes.InvalidArgument, "unknown mode %v", v[0])
+			return
+		}
+		mode = pb.WriteMode(value)
+	}
+	v = md.Get("revision")
+	if len(v) > 0 {
+		revision = v[0]
+	}
+	v = md.Get("sha256")
+	if len(v) > 0 {
+		digest, err = checkDigest(v[0])
+	}
 	return
 }
+
+// validate expected hex SHA-256, empty is not checked
+func checkDigest(digest string) (string, error) {
+	if digest == "" {
+		return "", nil
+	}
+	digest = strings.ToLower(digest)
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+		return "", status.Error(codes.InvalidArgument, "invalid sha256")
+	}
+	return digest, nil
+}
 func (s *Server) Upload(stream pb.StorageService_UploadServer) error {
-	path := s.parseUploadMD(stream)
+	path, mode, revision, digest, err := s.parseUploadMD(stream)
+	if err != nil {
+		return err
+	}
 	if path == "" {
 		return status.Error(codes.InvalidArgument, "missing path")
 	}
+	if mode == pb.WriteMode_OVERWRITE_IF_UNCHANGED && revision == "" {
+		return status.Error(codes.InvalidArgument, "missing revision")
+	}
 
-	exist, err := s.FM.IsFileExist(path)
+	streamReader := new(pb.StreamReader)
+	streamReader.StorageService_UploadServer(stream)
+	response, err := s.write(path, mode, revision, digest, streamReader)
 	if err != nil {
 		return err
 	}
-	if exist {
-		return status.Errorf(codes.AlreadyExists, "file %v already exist", path)
+	return stream.SendAndClose(response)
+}
+
+// write content of r to path like Upload, digest is expected hex SHA-256
+func (s *Server) write(path string, mode pb.WriteMode, revision, digest string, r io.Reader) (*pb.UploadResponse, error) {
+	// fail early, before receiving whole file
+	if err := s.checkWriteMode(path, mode, revision); err != nil {
+		return nil, err
 	}
 
-	file, err := s.FM.Create(path)
+	// file appears only after whole stream is received
+	file, err := s.Backend.Create(path)
 	if err != nil {
-		return err
+		return nil, statusError(err)
 	}
-	defer file.Close()
+	defer file.Abort()
 
-	streamReader := new(pb.StreamReader)
-	streamReader.StorageService_UploadServer(stream)
-	_, err = io.Copy(file, streamReader)
+	hash := sha256.New()
+	sniff := new(sniffer)
+	_, err = io.Copy(io.MultiWriter(file, hash, sniff), r)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if digest != "" && digest != sum {
+		return nil, status.Errorf(codes.DataLoss, "sha256 of received data is %v, expected %v", sum, digest)
 	}
 
-	return stream.SendAndClose(new(pb.UploadResponse))
+	// check again, file could be changed while receiving
+	s.mu.Lock()
+	op := backend.OpCreate
+	err = s.checkWriteMode(path, mode, revision)
+	if err == nil {
+		if exist, _ := backend.IsExist(s.Backend, path); exist {
+			op = backend.OpModify
+		}
+		defer s.Events.Expect(path)()
+		err = statusError(file.Commit())
+	}
+	if err == nil {
+		s.setDigest(path, sum)
+		s.setMIMEType(path, detectType(sniff.head, path))
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	s.Index.Update(path)
+	s.changed(backend.Change{Op: op, Path: path})
+
+	info, _, err := s.Backend.Stat(path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	response := &pb.UploadResponse{}
+	if info != nil {
+		response.Revision = fileRevision(info)
+	}
+	// same digest as Stat and Download return, so that clients build the
+	// same ETag from it
+	if response.Sha256, err = s.digest(path); err != nil {
+		return nil, statusError(err)
+	}
+	return response, nil
+}
+
+// check that file at path can be written with mode
+func (s *Server) checkWriteMode(path string, mode pb.WriteMode, revision string) error {
+	info, exist, err := s.Backend.Stat(path)
+	if err != nil {
+		return statusError(err)
+	}
+	if exist && info.IsDir() {
+		return status.Errorf(codes.AlreadyExists, "directory %v already exist", path)
+	}
+
+	switch mode {
+	case pb.WriteMode_CREATE:
+		if exist {
+			return status.Errorf(codes.AlreadyExists, "file %v already exist", path)
+		}
+	case pb.WriteMode_OVERWRITE_IF_UNCHANGED:
+		if !exist {
+			return status.Errorf(codes.NotFound, "file %v not found", path)
+		}
+		if fileRevision(info) != revision {
+			return status.Errorf(codes.FailedPrecondition, "file %v changed", path)
+		}
+	}
+	return nil
+}
+
+// fileRevision identifies content of file, it changes when file is rewritten
+func fileRevision(info fs.FileInfo) string {
+	return strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36)
+}
+
+// stored hex SHA-256 of file, empty if backend doesn't store digests
+func (s *Server) digest(path string) (string, error) {
+	digester, ok := s.Backend.(backend.Digester)
+	if !ok {
+		return "", nil
+	}
+	return digester.Digest(path, "sha256")
+}
+
+// store digest of just written file, failure only loses digest
+func (s *Server) setDigest(path, digest string) {
+	digester, ok := s.Backend.(backend.Digester)
+	if !ok {
+		return
+	}
+	if err := digester.SetDigest(path, "sha256", digest); err != nil {
+		log.Printf("set digest of %v: %v", path, err)
+	}
+}
+
+// convert backend errors to grpc status
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, backend.ErrInvalidPath):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, backend.ErrOutsideRoot):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, fs.ErrExist):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, syscall.ENOTDIR):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return err
+	}
 }
 
 // print result of request
@@ -212,9 +731,9 @@ func UnaryLogger() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 		resp, err = handler(ctx, req)
 		if err == nil {
-			log.Printf("%v success\n", info.FullMethod)
+			log.Printf("%v%v success\n", info.FullMethod, caller(ctx))
 		} else {
-			log.Printf("%v error: %v\n", info.FullMethod, err)
+			log.Printf("%v%v error: %v\n", info.FullMethod, caller(ctx), err)
 		}
 		return resp, err
 	}
@@ -223,9 +742,9 @@ func StreamLogger() grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		err := handler(srv, ss)
 		if err == nil {
-			log.Printf("%v success\n", info.FullMethod)
+			log.Printf("%v%v success\n", info.FullMethod, caller(ss.Context()))
 		} else {
-			log.Printf("%v error: %v\n", info.FullMethod, err)
+			log.Printf("%v%v error: %v\n", info.FullMethod, caller(ss.Context()), err)
 		}
 		return err
 	}
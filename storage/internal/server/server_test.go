@@ -1,120 +1,134 @@
 package server
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
+	"io/fs"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 
 	pb "github.com/muskelo/ns_server/protos/storage"
+	"github.com/muskelo/ns_server/storage/internal/backend"
+	"github.com/muskelo/ns_server/storage/internal/events"
 	"github.com/muskelo/ns_server/storage/internal/filemanager"
+	"github.com/muskelo/ns_server/storage/internal/index"
+	"github.com/muskelo/ns_server/storage/internal/journal"
+	"github.com/muskelo/ns_server/storage/internal/memory"
+	"github.com/muskelo/ns_server/storage/internal/thumbnail"
+	"github.com/muskelo/ns_server/storage/internal/uploads"
 )
 
-var (
-	// init by runTestServer
-	lis    *bufconn.Listener
-	fmPath string
-	// init by initTestClient
-	client pb.StorageServiceClient
-)
-
-func runTestServer() {
-	var err error
-	fmPath, err = filepath.Abs("../../test/fm")
-	if err != nil {
-		panic(err)
-	}
-	lis = bufconn.Listen(1024 * 1024)
-
-	fm := &filemanager.FileManager{Root: fmPath}
-	server := New(fm)
-
-	s := grpc.NewServer()
-	pb.RegisterStorageServiceServer(s, server)
-	if err := s.Serve(lis); err != nil {
-		panic(err)
-	}
-	return
-}
-
-func bufDialer(context.Context, string) (net.Conn, error) {
-	return lis.Dial()
+var testFiles = map[string]string{
+	"/file1.txt":      "i'm test file with name file1.\n",
+	"/file2.txt":      "i'm test file with name file2.\n",
+	"/dir1/file3.txt": "i'm file with name file3.txt and path dir1/file3\n",
+	"/dir2/file4.txt": "i'm file with name file4.txt and path dir2/file4\n",
 }
 
-func initTestClient() {
-	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		panic(err)
+// create memory backend with testFiles
+func newTestBackend(t *testing.T) backend.Backend {
+	t.Helper()
+	b := memory.New()
+	for _, dir := range []string{"/dir1", "/dir2"} {
+		if err := b.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
 	}
-	client = pb.NewStorageServiceClient(conn)
-}
-
-func cleanup() {
-	os.Remove(filepath.Join(fmPath, "dir1/file4.txt"))
-    os.RemoveAll(filepath.Join(fmPath, "dir3"))
+	for path, data := range testFiles {
+		file, err := b.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
 }
 
-func TestMain(t *testing.M) {
-	go runTestServer()
-	initTestClient()
-	code := t.Run()
-	cleanup()
-	os.Exit(code)
+// run server with backend on in-memory listener and return client for it
+func newTestClient(t *testing.T, b backend.Backend) pb.StorageServiceClient {
+	t.Helper()
+	return newTestServerClient(t, New(b))
 }
 
-/*
-Tests
-*/
-
-func TestServer(t *testing.T) {
-	err := testReadDir()
-	if err != nil {
-		t.Errorf("testReadDir() Err: %v", err)
-		return
-	}
+func newTestServerClient(t *testing.T, server *Server) pb.StorageServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterStorageServiceServer(s, server)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
 
-	err = testDownload(t.TempDir())
-	if err != nil {
-		t.Errorf("testDownload() Err: %v", err)
-		return
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
 	}
-
-	err = testUpload()
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
-		t.Errorf("testDownload() Err: %v", err)
-		return
+		t.Fatal(err)
 	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewStorageServiceClient(conn)
+}
 
-	err = testRemove()
+func readFile(b backend.Backend, path string) ([]byte, error) {
+	file, err := b.Open(path)
 	if err != nil {
-		t.Errorf("testRemove() Err: %v", err)
-		return
+		return nil, err
 	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
 
-	err = testMkdir()
+// upload data with metadata pairs in kv
+func upload(client pb.StorageServiceClient, data []byte, kv ...string) (*pb.UploadResponse, error) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), kv...)
+	stream, err := client.Upload(ctx)
 	if err != nil {
-		t.Errorf("testMkdir() Err: %v", err)
-		return
+		return nil, err
 	}
-
-    err = testRemoveAll()
-	if err != nil {
-		t.Errorf("testMkdir() Err: %v", err)
-		return
+	streamWriter := new(pb.StreamWriter)
+	streamWriter.StorageService_UploadClient(stream)
+	if _, err := io.Copy(streamWriter, bytes.NewReader(data)); err != nil {
+		return nil, err
 	}
+	return stream.CloseAndRecv()
 }
 
-func testReadDir() error {
+/*
+Tests
+*/
+
+func TestReadDir(t *testing.T) {
+	t.Parallel()
+	client := newTestClient(t, newTestBackend(t))
+
 	wantDirs := []*pb.ReadDirResponse_Dir{
 		{
 			Name: "dir1",
@@ -141,156 +155,1636 @@ func testReadDir() error {
 	}
 	response, err := client.ReadDir(context.Background(), request)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
+	if len(response.Dirs) != len(wantDirs) || len(response.Files) != len(wantFiles) {
+		t.Fatalf("response = %v", response)
+	}
 	for i, dir := range response.Dirs {
 		if dir.Name != wantDirs[i].Name || dir.Path != wantDirs[i].Path {
-			return fmt.Errorf("dir = %v, want %v", dir, wantDirs[i])
+			t.Errorf("dir = %v, want %v", dir, wantDirs[i])
 		}
 	}
 	for i, file := range response.Files {
 		if file.Name != wantFiles[i].Name || file.Path != wantFiles[i].Path {
-			return fmt.Errorf("file = %v, want %v", file, wantFiles[i])
+			t.Errorf("file = %v, want %v", file, wantFiles[i])
+		}
+	}
+}
+
+func TestReadDirPage(t *testing.T) {
+	t.Parallel()
+	b := memory.New()
+	client := newTestClient(t, b)
+	for _, dir := range []string{"/b", "/a", "/a/sub"} {
+		if err := b.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"file10.txt", "File2.txt", "file1.txt", "image.png"} {
+		if _, err := upload(client, bytes.Repeat([]byte("x"), len(name)), "path", "/"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// names of all pages
+	list := func(request *pb.ReadDirRequest) []string {
+		t.Helper()
+		names := make([]string, 0)
+		for {
+			response, err := client.ReadDir(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, dir := range response.Dirs {
+				names = append(names, dir.Name+"/")
+			}
+			for _, file := range response.Files {
+				names = append(names, file.Name)
+			}
+			if response.NextPageToken == "" {
+				return names
+			}
+			request.PageToken = response.NextPageToken
+		}
+	}
+
+	tests := []struct {
+		request *pb.ReadDirRequest
+		want    string
+	}{
+		{&pb.ReadDirRequest{Path: "/", PageSize: 2}, "a/ b/ file1.txt File2.txt file10.txt image.png"},
+		{&pb.ReadDirRequest{Path: "/", PageSize: 4, Descending: true}, "b/ a/ image.png file10.txt File2.txt file1.txt"},
+		{&pb.ReadDirRequest{Path: "/", Sort: pb.ReadDirRequest_SIZE}, "a/ b/ file1.txt File2.txt image.png file10.txt"},
+		{&pb.ReadDirRequest{Path: "/", PageSize: 1, Filter: "FILE*.TXT"}, "file1.txt File2.txt file10.txt"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(list(test.request), " "); got != test.want {
+			t.Errorf("ReadDir(%v) = %v, want %v", test.request, got, test.want)
+		}
+	}
+
+	response, err := client.ReadDir(context.Background(), &pb.ReadDirRequest{Path: "/", Filter: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Dirs) != 1 || response.Dirs[0].Dirs != 1 || response.Dirs[0].Files != 0 || response.Dirs[0].ModTime == 0 {
+		t.Errorf("ReadDir(a) = %v", response)
+	}
+	response, err = client.ReadDir(context.Background(), &pb.ReadDirRequest{Path: "/", Filter: "image.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Files) != 1 || response.Files[0].Size != 9 || response.Files[0].ModTime == 0 {
+		t.Errorf("ReadDir(image.png) = %v", response)
+	}
+
+	// entries created or removed between pages don't shift next page
+	first, err := client.ReadDir(context.Background(), &pb.ReadDirRequest{Path: "/", PageSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove("/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload(client, []byte("x"), "path", "/file3.txt"); err != nil {
+		t.Fatal(err)
+	}
+	got := list(&pb.ReadDirRequest{Path: "/", PageSize: 1, PageToken: first.NextPageToken})
+	if want := "File2.txt file3.txt file10.txt image.png"; strings.Join(got, " ") != want {
+		t.Errorf("pages after change = %v, want %v", got, want)
+	}
+
+	for _, request := range []*pb.ReadDirRequest{{Path: "/", PageToken: "x"}, {Path: "/", PageToken: "f:x:a"}, {Path: "/", Filter: "["}} {
+		_, err := client.ReadDir(context.Background(), request)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("ReadDir(%v) err = %v, want %v", request, err, codes.InvalidArgument)
+		}
+	}
+}
+
+type failingReadDir struct {
+	backend.Backend
+	path string
+}
+
+func (b failingReadDir) ReadDir(path string) ([]backend.File, []backend.Directory, error) {
+	if path == b.path {
+		return nil, nil, os.ErrPermission
+	}
+	return b.Backend.ReadDir(path)
+}
+
+func TestReadDirCounts(t *testing.T) {
+	t.Parallel()
+	b := memory.New()
+	for i := 0; i < maxCountedDirs+1; i++ {
+		if err := b.Mkdir(fmt.Sprintf("/dir%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Mkdir("/dir000/sub"); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, failingReadDir{b, "/dir001"})
+
+	response, err := client.ReadDir(context.Background(), &pb.ReadDirRequest{Path: "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := func(dir *pb.ReadDirResponse_Dir) string {
+		return fmt.Sprintf("%v %v", dir.Files, dir.Dirs)
+	}
+	want := map[int]string{0: "0 1", 1: "-1 -1", 2: "0 0", maxCountedDirs: "-1 -1"}
+	for i, w := range want {
+		if got := counts(response.Dirs[i]); got != w {
+			t.Errorf("counts of %v = %v, want %v", response.Dirs[i].Name, got, w)
+		}
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	for _, test := range []struct{ a, b string }{
+		{"file2", "file10"},
+		{"File2", "file10"},
+		{"a", "B"},
+		{"file", "file1"},
+		{"x01", "x001"},
+		{"x9y", "x09z"},
+		{"File", "file"},
+	} {
+		if !naturalLess(test.a, test.b) || naturalLess(test.b, test.a) {
+			t.Errorf("naturalLess(%q, %q) is wrong", test.a, test.b)
 		}
 	}
-	return nil
 }
 
-func testDownload(tempDir string) error {
+func TestDownload(t *testing.T) {
+	t.Parallel()
+	client := newTestClient(t, newTestBackend(t))
+
 	ctx := metadata.AppendToOutgoingContext(context.TODO(), "path", "dir1/file3.txt")
 	request := &pb.DownloadRequest{}
 	stream, err := client.Download(ctx, request)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
 	streamReader := new(pb.StreamReader)
 	streamReader.StorageService_DownloadClient(stream)
 
-	outPath := filepath.Join(tempDir, "test.txt")
-	outFile, err := os.Create(outPath)
+	data, err := io.ReadAll(streamReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testFiles["/dir1/file3.txt"] {
+		t.Errorf("Downloaded file if different from src")
+	}
+
+	md, err := stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSize := fmt.Sprint(len(testFiles["/dir1/file3.txt"]))
+	if name, size := md.Get("name"), md.Get("size"); len(name) != 1 || name[0] != "file3.txt" || len(size) != 1 || size[0] != wantSize {
+		t.Errorf("header = %v", md)
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
+	content := testFiles["/file1.txt"]
+	info, _, err := b.Stat("/file1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	download := func(request *pb.DownloadRequest) (string, error) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "path", "/file1.txt")
+		stream, err := client.Download(ctx, request)
+		if err != nil {
+			return "", err
+		}
+		streamReader := new(pb.StreamReader)
+		streamReader.StorageService_DownloadClient(stream)
+		data, err := io.ReadAll(streamReader)
+		return string(data), err
+	}
+	tests := []struct {
+		request *pb.DownloadRequest
+		want    string
+	}{
+		{&pb.DownloadRequest{Offset: 4}, content[4:]},
+		{&pb.DownloadRequest{Offset: 4, Length: 4}, content[4:8]},
+		{&pb.DownloadRequest{Offset: 20, Length: 100}, content[20:]},
+		{&pb.DownloadRequest{Offset: int64(len(content))}, ""},
+		{&pb.DownloadRequest{Length: 3, Revision: fileRevision(info)}, content[:3]},
+	}
+	for _, test := range tests {
+		if got, err := download(test.request); err != nil || got != test.want {
+			t.Errorf("Download(%v) = %q, %v, want %q", test.request, got, err, test.want)
+		}
+	}
+
+	errTests := []struct {
+		request *pb.DownloadRequest
+		code    codes.Code
+	}{
+		{&pb.DownloadRequest{Offset: int64(len(content)) + 1}, codes.OutOfRange},
+		{&pb.DownloadRequest{Offset: -1}, codes.InvalidArgument},
+		{&pb.DownloadRequest{Revision: "old"}, codes.FailedPrecondition},
+	}
+	for _, test := range errTests {
+		if _, err := download(test.request); status.Code(err) != test.code {
+			t.Errorf("Download(%v) err = %v, want %v", test.request, err, test.code)
+		}
+	}
+}
+
+func TestDownloadArchive(t *testing.T) {
+	t.Parallel()
+	client := newTestClient(t, newTestBackend(t))
+
+	download := func(path string, format pb.ArchiveFormat) ([]byte, metadata.MD, error) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "path", path)
+		stream, err := client.Download(ctx, &pb.DownloadRequest{Archive: format})
+		if err != nil {
+			return nil, nil, err
+		}
+		streamReader := new(pb.StreamReader)
+		streamReader.StorageService_DownloadClient(stream)
+		data, err := io.ReadAll(streamReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		md, err := stream.Header()
+		return data, md, err
+	}
+	want := "dir1/ dir1/file3.txt=" + testFiles["/dir1/file3.txt"]
+
+	readTar := func(r io.Reader) (string, error) {
+		entries := make([]string, 0)
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return strings.Join(entries, " "), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return "", err
+			}
+			if header.Typeflag == tar.TypeReg {
+				entries = append(entries, header.Name+"="+string(content))
+			} else {
+				entries = append(entries, header.Name)
+			}
+		}
+	}
+
+	data, md, err := download("/dir1", pb.ArchiveFormat_TAR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := md.Get("name"); len(name) != 1 || name[0] != "dir1.tar" {
+		t.Errorf("name = %v, want dir1.tar", name)
+	}
+	if got, err := readTar(bytes.NewReader(data)); err != nil || got != want {
+		t.Errorf("tar = %q, %v, want %q", got, err, want)
+	}
+
+	data, _, err = download("/dir1", pb.ArchiveFormat_TAR_GZ)
 	if err != nil {
-		return err
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readTar(gz); err != nil || got != want {
+		t.Errorf("tar.gz = %q, %v, want %q", got, err, want)
 	}
-	defer outFile.Close()
 
-	_, err = io.Copy(outFile, streamReader)
+	data, _, err = download("/dir1", pb.ArchiveFormat_ZIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
-		return err
+		t.Fatal(err)
+	}
+	entries := make([]string, 0)
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() {
+			entries = append(entries, file.Name)
+			continue
+		}
+		content, err := readZipFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, file.Name+"="+string(content))
+	}
+	if got := strings.Join(entries, " "); got != want {
+		t.Errorf("zip = %q, want %q", got, want)
+	}
+
+	if _, _, err := download("/dir1", pb.ArchiveFormat_NONE); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Download(dir) err = %v, want %v", err, codes.FailedPrecondition)
+	}
+	if _, _, err := download("/file1.txt", pb.ArchiveFormat_ZIP); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Download(file as zip) err = %v, want %v", err, codes.FailedPrecondition)
+	}
+}
+
+func TestDownloadArchiveSymlinks(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dir", "sub"), 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dir", "sub", "file"), []byte("data"), 0660); err != nil {
+		t.Fatal(err)
 	}
+	for link, target := range map[string]string{"file-link": "sub/file", "sub-link": "sub", "loop": ".", "broken": "missing"} {
+		if err := os.Symlink(target, filepath.Join(root, "dir", link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := newTestClient(t, &filemanager.FileManager{Root: root})
 
-	equal, err := compareFiles(filepath.Join(fmPath, "dir1/file3.txt"), outPath)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "path", "/dir")
+	stream, err := client.Download(ctx, &pb.DownloadRequest{Archive: pb.ArchiveFormat_TAR})
 	if err != nil {
-		return err
+		t.Fatal(err)
+	}
+	streamReader := new(pb.StreamReader)
+	streamReader.StorageService_DownloadClient(stream)
+	entries := make([]string, 0)
+	tr := tar.NewReader(streamReader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, header.Name)
+	}
+	want := "dir/ dir/file-link dir/sub/ dir/sub/file"
+	if got := strings.Join(entries, " "); got != want {
+		t.Errorf("tar = %q, want %q", got, want)
 	}
-	if !equal {
-		return fmt.Errorf("Downloaded file if different from src")
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	defer r.Close()
+	return io.ReadAll(r)
 }
 
-func testUpload() error {
+func TestUpload(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
+
 	ctx := metadata.AppendToOutgoingContext(context.TODO(), "path", "dir1/file4.txt")
 	stream, err := client.Upload(ctx)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
 	streamWriter := new(pb.StreamWriter)
 	streamWriter.StorageService_UploadClient(stream)
 
-	file, err := os.Open("../../test/fm/dir1/file3.txt")
+	src := []byte(testFiles["/dir1/file3.txt"])
+	_, err = io.Copy(streamWriter, bytes.NewReader(src))
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
-	_, err = io.Copy(streamWriter, file)
+	_, err = stream.CloseAndRecv()
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
-	_, err = stream.CloseAndRecv()
+	data, err := readFile(b, "/dir1/file4.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, src) {
+		t.Errorf("Uploaded file if different from src")
+	}
+}
+
+func TestUploadDigest(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
+
+	data := []byte("checked content")
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+	wrong := hex.EncodeToString(make([]byte, sha256.Size))
+
+	if _, err := upload(client, data, "path", "/bad.txt", "sha256", wrong); status.Code(err) != codes.DataLoss {
+		t.Errorf("Upload with wrong digest err = %v, want DataLoss", err)
+	}
+	if exist, _ := backend.IsExist(b, "/bad.txt"); exist {
+		t.Errorf("file with wrong digest is created")
+	}
+	if _, err := upload(client, data, "path", "/bad.txt", "sha256", "xyz"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Upload with invalid digest err = %v, want InvalidArgument", err)
+	}
+
+	response, err := upload(client, data, "path", "/good.txt", "sha256", strings.ToUpper(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Sha256 != want {
+		t.Errorf("Upload sha256 = %v, want %v", response.Sha256, want)
+	}
+	stat, err := client.Stat(context.Background(), &pb.StatRequest{Path: "/good.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Sha256 != want {
+		t.Errorf("Stat sha256 = %v, want %v", stat.Sha256, want)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "path", "/good.txt")
+	stream, err := client.Download(ctx, &pb.DownloadRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := md.Get("sha256"); len(got) != 1 || got[0] != want {
+		t.Errorf("Download header sha256 = %v, want %v", got, want)
+	}
+
+	// digest that isn't stored isn't returned by Upload either
+	client = newTestClient(t, noDigests{b})
+	response, err = upload(client, data, "path", "/plain.txt", "sha256", want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err = client.Stat(context.Background(), &pb.StatRequest{Path: "/plain.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Sha256 != stat.Sha256 || response.Revision != stat.Revision {
+		t.Errorf("Upload = %v, %v, Stat = %v, %v", response.Sha256, response.Revision, stat.Sha256, stat.Revision)
+	}
+}
+
+// backend without Digester
+type noDigests struct {
+	backend.Backend
+}
+
+func TestResumableUpload(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	s := New(b)
+	client := newTestServerClient(t, s)
+	if _, err := client.CreateUpload(context.Background(), &pb.CreateUploadRequest{Path: "/r.txt", Size: 1}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("CreateUpload without staging err = %v, want Unimplemented", err)
+	}
+	store, err := uploads.New(t.TempDir(), time.Hour)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
+	s.Uploads = store
 
-	ok, err := compareFiles(filepath.Join(fmPath, "/dir1/file3.txt"), filepath.Join(fmPath, "/dir1/file4.txt"))
+	appendUpload := func(id string, offset int, data string) (*pb.UploadStatusResponse, error) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "id", id, "offset", fmt.Sprint(offset))
+		stream, err := client.AppendUpload(ctx)
+		if err != nil {
+			return nil, err
+		}
+		w := new(pb.StreamWriter)
+		w.StorageService_AppendUploadClient(stream)
+		if _, err := io.WriteString(w, data); err != nil {
+			return nil, err
+		}
+		return stream.CloseAndRecv()
+	}
+
+	data := "0123456789"
+	sum := sha256.Sum256([]byte(data))
+	upload, err := client.CreateUpload(context.Background(), &pb.CreateUploadRequest{Path: "/r.txt", Size: 10, Sha256: hex.EncodeToString(sum[:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, err := appendUpload(upload.Id, 0, data[:4]); err != nil || response.Offset != 4 || response.Result != nil {
+		t.Fatalf("AppendUpload = %v, %v, want offset 4", response, err)
+	}
+	if _, err := appendUpload(upload.Id, 2, data[2:]); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("AppendUpload(wrong offset) err = %v, want FailedPrecondition", err)
+	}
+	if response, err := client.UploadStatus(context.Background(), &pb.UploadStatusRequest{Id: upload.Id}); err != nil || response.Offset != 4 {
+		t.Errorf("UploadStatus = %v, %v, want offset 4", response, err)
+	}
+	if exist, _ := backend.IsExist(b, "/r.txt"); exist {
+		t.Errorf("incomplete upload is written")
+	}
+	response, err := appendUpload(upload.Id, 4, data[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Offset != 10 || response.Result == nil || response.Result.Sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("AppendUpload(last) = %v, want offset 10 and result", response)
+	}
+	if got, err := readFile(b, "/r.txt"); err != nil || string(got) != data {
+		t.Errorf("written file = %q, %v, want %q", got, err, data)
+	}
+	if _, err := client.UploadStatus(context.Background(), &pb.UploadStatusRequest{Id: upload.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("UploadStatus(written) err = %v, want NotFound", err)
+	}
+
+	// empty file is written at once, existing file fails before upload
+	if response, err := client.CreateUpload(context.Background(), &pb.CreateUploadRequest{Path: "/empty.txt"}); err != nil || response.Result == nil {
+		t.Errorf("CreateUpload(empty) = %v, %v, want result", response, err)
+	}
+	if _, err := client.CreateUpload(context.Background(), &pb.CreateUploadRequest{Path: "/r.txt", Size: 1}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateUpload(existing) err = %v, want AlreadyExists", err)
+	}
+
+	upload, err = client.CreateUpload(context.Background(), &pb.CreateUploadRequest{Path: "/aborted.txt", Size: 5})
 	if err != nil {
-		return err
+		t.Fatal(err)
+	}
+	if _, err := client.AbortUpload(context.Background(), &pb.AbortUploadRequest{Id: upload.Id}); err != nil {
+		t.Fatal(err)
 	}
-	if !ok {
-		return fmt.Errorf("Uploaded file if different from src")
+	if _, err := appendUpload(upload.Id, 0, "abc"); status.Code(err) != codes.NotFound {
+		t.Errorf("AppendUpload(aborted) err = %v, want NotFound", err)
 	}
-	return nil
 }
 
-func testRemove() error {
-	_, err := client.Remove(context.Background(), &pb.RemoveRequest{Path: "dir1/file4.txt"})
+func TestMIMEType(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
+	digester := b.(backend.Digester)
+	const html = "text/html; charset=utf-8"
+	const text = "text/plain; charset=utf-8"
+
+	// stored on upload, by content whatever extension is
+	if _, err := upload(client, []byte("<html><body>hi</body></html>"), "path", "/page.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := digester.Digest("/page.txt", "mime"); err != nil || stored != html {
+		t.Errorf("stored type = %q, %v, want %q", stored, err, html)
+	}
+
+	// detected on read for files written before, read path doesn't store
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "path", "/file1.txt")
+	stream, err := client.Download(ctx, &pb.DownloadRequest{})
 	if err != nil {
-		return err
+		t.Fatal(err)
+	}
+	md, err := stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := md.Get("mime_type"); len(v) != 1 || v[0] != text {
+		t.Errorf("mime_type metadata = %v, want %v", v, text)
+	}
+	if stored, err := digester.Digest("/file1.txt", "mime"); err != nil || stored != "" {
+		t.Errorf("stored type = %q, %v, want none", stored, err)
 	}
 
-	_, err = os.Stat(filepath.Join(fmPath, "dir1/file4.txt"))
-	switch {
-	case err == nil:
-		return fmt.Errorf("File exist")
-	case errors.Is(err, os.ErrNotExist):
-		return nil
-	default:
-		return err
+	// listing uses stored type or extension, not content
+	response, err := client.ReadDir(context.Background(), &pb.ReadDirRequest{Path: "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]string)
+	for _, file := range response.Files {
+		types[file.Name] = file.MimeType
+	}
+	if types["page.txt"] != html || types["file1.txt"] != text || types["file2.txt"] != text {
+		t.Errorf("ReadDir types = %v", types)
 	}
 }
 
-func testMkdir() error {
-	_, err := client.Mkdir(context.Background(), &pb.MkdirRequest{Path: "/dir3"})
+func TestUploadMode(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
+
+	_, err := upload(client, []byte("new"), "path", "file1.txt")
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("create existing err = %v, want %v", err, codes.AlreadyExists)
+	}
+	_, err = upload(client, []byte("new"), "path", "file1.txt", "mode", "APPEND")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unknown mode err = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	response, err := upload(client, []byte("overwritten"), "path", "file1.txt", "mode", pb.WriteMode_OVERWRITE.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := readFile(b, "file1.txt"); string(data) != "overwritten" {
+		t.Errorf("content = %q, want %q", data, "overwritten")
+	}
+
+	mode := pb.WriteMode_OVERWRITE_IF_UNCHANGED.String()
+	_, err = upload(client, []byte("stale"), "path", "file1.txt", "mode", mode, "revision", "stale")
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("stale revision err = %v, want %v", err, codes.FailedPrecondition)
+	}
+	_, err = upload(client, []byte("missing"), "path", "missing.txt", "mode", mode, "revision", response.Revision)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("missing file err = %v, want %v", err, codes.NotFound)
+	}
+	_, err = upload(client, []byte("unchanged"), "path", "file1.txt", "mode", mode, "revision", response.Revision)
 	if err != nil {
-		return err
+		t.Fatal(err)
+	}
+	if data, _ := readFile(b, "file1.txt"); string(data) != "unchanged" {
+		t.Errorf("content = %q, want %q", data, "unchanged")
 	}
-	_, err = os.Stat(filepath.Join(fmPath, "dir3"))
-	return err
 }
 
-func testRemoveAll() error {
-    _, err := client.Mkdir(context.Background(), &pb.MkdirRequest{Path: "/dir3/dir4"})
-    if err != nil {
-        return err
-    }
+func TestUploadInterrupted(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	client := newTestClient(t, &filemanager.FileManager{Root: root})
 
-    _, err = client.RemoveAll(context.Background(), &pb.RemoveAllRequest{Path: "/dir3"})
-    if err != nil {
-        return err
-    }
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = metadata.AppendToOutgoingContext(ctx, "path", "file.txt")
+	stream, err := client.Upload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.UploadRequest{Chunk: []byte("partial")}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
 
-	_, err = os.Stat(filepath.Join(fmPath, "dir3"))
-	switch {
-	case err == nil:
-		return fmt.Errorf("File exist")
-	case errors.Is(err, os.ErrNotExist):
-		return nil
-	default:
-		return err
+	// server drops temporary file after noticing disconnect
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("entries left after interrupted upload: %v", entries)
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
 }
 
-func compareFiles(file1, file2 string) (bool, error) {
-	f1, err := os.Open(file1)
+func TestRemove(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
+
+	_, err := client.Remove(context.Background(), &pb.RemoveRequest{Path: "dir1/file3.txt"})
 	if err != nil {
-		return false, err
+		t.Fatal(err)
 	}
-	f2, err := os.Open(file2)
+
+	exist, err := backend.IsExist(b, "dir1/file3.txt")
 	if err != nil {
-		return false, err
+		t.Fatal(err)
+	}
+	if exist {
+		t.Errorf("File exist")
+	}
+
+	_, err = client.Remove(context.Background(), &pb.RemoveRequest{Path: "dir2"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Remove(not empty dir) err = %v, want %v", err, codes.FailedPrecondition)
 	}
 
-	data1, err := io.ReadAll(f1)
+	_, err = client.Remove(context.Background(), &pb.RemoveRequest{Path: "/file1.txt", Revision: "old"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Remove(changed) err = %v, want %v", err, codes.FailedPrecondition)
+	}
+	info, _, err := b.Stat("/file1.txt")
 	if err != nil {
-		return false, err
+		t.Fatal(err)
 	}
-	data2, err := io.ReadAll(f2)
+	_, err = client.Remove(context.Background(), &pb.RemoveRequest{Path: "/file1.txt", Revision: fileRevision(info)})
 	if err != nil {
-		return false, err
+		t.Errorf("Remove(unchanged) err = %v", err)
 	}
+}
+
+func TestMkdir(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
 
-	return bytes.Equal(data1, data2), nil
+	_, err := client.Mkdir(context.Background(), &pb.MkdirRequest{Path: "/dir3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exist, err := backend.IsDirExist(b, "/dir3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exist {
+		t.Errorf("Directory not exist")
+	}
+
+	_, err = client.Mkdir(context.Background(), &pb.MkdirRequest{Path: "/dir3"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Mkdir(existing) err = %v, want %v", err, codes.AlreadyExists)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
+
+	_, err := client.Mkdir(context.Background(), &pb.MkdirRequest{Path: "/dir3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Mkdir(context.Background(), &pb.MkdirRequest{Path: "/dir3/dir4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := upload(client, []byte("data"), "path", "/dir3/dir4/file5.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := client.RemoveAll(context.Background(), &pb.RemoveAllRequest{Path: "/dir3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Files != 1 || response.Dirs != 2 {
+		t.Errorf("removed %v files, %v dirs, want 1, 2", response.Files, response.Dirs)
+	}
+
+	exist, err := backend.IsExist(b, "/dir3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exist {
+		t.Errorf("File exist")
+	}
+}
+
+// backend failing to remove one path
+type failingRemove struct {
+	backend.Backend
+	path string
+}
+
+func (b failingRemove) Remove(path string) error {
+	if path == b.path {
+		return os.ErrPermission
+	}
+	return b.Backend.Remove(path)
+}
+
+func TestRemoveAllPartial(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	if err := b.Mkdir("/dir1/sub"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/dir1/sub/a.txt", "/dir1/sub/b.txt"} {
+		if _, err := upload(newTestClient(t, b), []byte("data"), "path", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := New(failingRemove{b, "/dir1/sub"})
+	j, err := journal.Open("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Journal = j
+	cursor := j.Cursor()
+
+	_, err = newTestServerClient(t, s).RemoveAll(context.Background(), &pb.RemoveAllRequest{Path: "/dir1"})
+	if err == nil {
+		t.Fatal("RemoveAll with failing remove succeeded")
+	}
+	// removed files are in journal though tree is left
+	entries, _, _, err := j.List(cursor, "/", 0)
+	removed := make([]string, 0)
+	for _, entry := range entries {
+		removed = append(removed, entry.Change.Path)
+	}
+	if got := strings.Join(removed, " "); err != nil || got != "/dir1/file3.txt /dir1/sub/a.txt /dir1/sub/b.txt" {
+		t.Errorf("journal after failed RemoveAll = %v, %v", got, err)
+	}
+}
+
+func TestRemoveAllErrors(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
+
+	_, err := client.RemoveAll(context.Background(), &pb.RemoveAllRequest{Path: "/"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("RemoveAll(root) err = %v, want %v", err, codes.InvalidArgument)
+	}
+	_, err = client.RemoveAll(context.Background(), &pb.RemoveAllRequest{Path: "/missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("RemoveAll(missing) err = %v, want %v", err, codes.NotFound)
+	}
+
+	// canceled request removes nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = New(b).RemoveAll(ctx, &pb.RemoveAllRequest{Path: "/dir1"})
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("RemoveAll(canceled) err = %v, want %v", err, codes.Canceled)
+	}
+	if ok, _ := backend.IsFileExist(b, "/dir1/file3.txt"); !ok {
+		t.Errorf("File removed after cancel")
+	}
+}
+
+func TestMove(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	client := newTestClient(t, b)
+	ctx := context.Background()
+
+	_, err := client.Move(ctx, &pb.MoveRequest{Src: "/file1.txt", Dst: "/file2.txt"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Move(CREATE over file) err = %v, want %v", err, codes.AlreadyExists)
+	}
+	_, err = client.Move(ctx, &pb.MoveRequest{Src: "/file1.txt", Dst: "/file2.txt", Mode: pb.WriteMode_OVERWRITE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readFile(b, "/file2.txt")
+	if err != nil || string(data) != testFiles["/file1.txt"] {
+		t.Errorf("moved file = %q, %v", data, err)
+	}
+
+	_, err = client.Move(ctx, &pb.MoveRequest{Src: "/dir1", Dst: "/dir2", Mode: pb.WriteMode_OVERWRITE})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Move(dir over dir) err = %v, want %v", err, codes.AlreadyExists)
+	}
+	_, err = client.Move(ctx, &pb.MoveRequest{Src: "/dir1", Dst: "/dir2/dir1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = readFile(b, "/dir2/dir1/file3.txt")
+	if err != nil || string(data) != testFiles["/dir1/file3.txt"] {
+		t.Errorf("file of moved dir = %q, %v", data, err)
+	}
+
+	_, err = client.Move(ctx, &pb.MoveRequest{Src: "/missing", Dst: "/new"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Move(missing) err = %v, want %v", err, codes.NotFound)
+	}
+	_, err = client.Move(ctx, &pb.MoveRequest{Src: "/dir2", Dst: "/dir2/sub"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Move(into itself) err = %v, want %v", err, codes.InvalidArgument)
+	}
+	_, err = client.Move(ctx, &pb.MoveRequest{Src: "/file2.txt", Dst: "/missing/file2.txt"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Move(to missing dir) err = %v, want %v", err, codes.NotFound)
+	}
+	_, err = client.Move(ctx, &pb.MoveRequest{Src: "/dir2", Dst: "/file2.txt/dir2"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Move(into file) err = %v, want %v", err, codes.FailedPrecondition)
+	}
+}
+
+// run Copy and return last progress message
+func copyTree(client pb.StorageServiceClient, request *pb.CopyRequest) (*pb.CopyResponse, error) {
+	stream, err := client.Copy(context.Background(), request)
+	if err != nil {
+		return nil, err
+	}
+	var last *pb.CopyResponse
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		last = response
+	}
+}
+
+func TestCopy(t *testing.T) {
+	t.Parallel()
+	backends := map[string]backend.Backend{
+		"memory": newTestBackend(t),
+		"local":  &filemanager.FileManager{Root: t.TempDir()},
+	}
+	local := backends["local"]
+	for _, dir := range []string{"/dir1", "/dir2"} {
+		if err := local.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, data := range testFiles {
+		if _, err := upload(newTestClient(t, local), []byte(data), "path", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, b := range backends {
+		client := newTestClient(t, b)
+
+		response, err := copyTree(client, &pb.CopyRequest{Src: "/dir1", Dst: "/dir2/copy"})
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		want := int64(len(testFiles["/dir1/file3.txt"]))
+		if !response.Done || response.Files != 1 || response.Bytes != want {
+			t.Errorf("%v: last progress = %v, want done, 1 file, %v bytes", name, response, want)
+		}
+		for _, path := range []string{"/dir1/file3.txt", "/dir2/copy/file3.txt"} {
+			data, err := readFile(b, path)
+			if err != nil || string(data) != testFiles["/dir1/file3.txt"] {
+				t.Errorf("%v: %v = %q, %v", name, path, data, err)
+			}
+		}
+
+		_, err = copyTree(client, &pb.CopyRequest{Src: "/file1.txt", Dst: "/file2.txt"})
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("%v: Copy(CREATE over file) err = %v, want %v", name, err, codes.AlreadyExists)
+		}
+		_, err = copyTree(client, &pb.CopyRequest{Src: "/dir1", Dst: "/dir2/copy"})
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("%v: Copy(over dir) err = %v, want %v", name, err, codes.AlreadyExists)
+		}
+		_, err = copyTree(client, &pb.CopyRequest{Src: "/file1.txt", Dst: "/file2.txt", Mode: pb.WriteMode_OVERWRITE})
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		data, err := readFile(b, "/file2.txt")
+		if err != nil || string(data) != testFiles["/file1.txt"] {
+			t.Errorf("%v: copied file = %q, %v", name, data, err)
+		}
+
+		_, err = copyTree(client, &pb.CopyRequest{Src: "/dir1", Dst: "/missing/copy"})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("%v: Copy(to missing dir) err = %v, want %v", name, err, codes.NotFound)
+		}
+		_, err = copyTree(client, &pb.CopyRequest{Src: "/file1.txt", Dst: "/file2.txt/copy"})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%v: Copy(into file) err = %v, want %v", name, err, codes.FailedPrecondition)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{&fs.PathError{Op: "rename", Path: "/a", Err: backend.ErrInvalidPath}, codes.InvalidArgument},
+		{&fs.PathError{Op: "open", Path: "/a", Err: backend.ErrOutsideRoot}, codes.PermissionDenied},
+		{&fs.PathError{Op: "rename", Path: "/a", Err: syscall.ENOENT}, codes.NotFound},
+		{&fs.PathError{Op: "rename", Path: "/a", Err: syscall.EEXIST}, codes.AlreadyExists},
+		{&fs.PathError{Op: "rename", Path: "/a", Err: syscall.ENOTDIR}, codes.FailedPrecondition},
+		{errors.New("other"), codes.Unknown},
+	}
+	for _, test := range tests {
+		if code := status.Code(statusError(test.err)); code != test.want {
+			t.Errorf("statusError(%v) = %v, want %v", test.err, code, test.want)
+		}
+	}
+}
+
+// zip with files, names ending with slash are directories
+func makeZip(t *testing.T, files ...string) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(name, "/") {
+			f.Write([]byte("content of " + name))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	t.Parallel()
+	backends := map[string]backend.Backend{
+		"memory": memory.New(),
+		"local":  &filemanager.FileManager{Root: t.TempDir()},
+	}
+	for name, b := range backends {
+		client := newTestClient(t, b)
+		if _, err := upload(client, makeZip(t, "x/", "x/a.txt", "y/b.txt"), "path", "/p.zip"); err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := client.Extract(context.Background(), &pb.ExtractRequest{Path: "/p.zip", Dir: "/out"})
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if response.Files != 2 || response.Dirs != 3 || response.Skipped != 0 {
+			t.Errorf("%v: Extract = %v, want 2 files and 3 dirs", name, response)
+		}
+		for _, path := range []string{"x/a.txt", "y/b.txt"} {
+			if data, err := readFile(b, "/out/"+path); err != nil || string(data) != "content of "+path {
+				t.Errorf("%v: %v = %q, %v", name, path, data, err)
+			}
+		}
+
+		_, err = client.Extract(context.Background(), &pb.ExtractRequest{Path: "/p.zip", Dir: "/out"})
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("%v: Extract(existing) err = %v, want %v", name, err, codes.AlreadyExists)
+		}
+		response, err = client.Extract(context.Background(), &pb.ExtractRequest{Path: "/p.zip", Dir: "/out", Conflict: pb.ExtractConflict_SKIP})
+		if err != nil || response.Files != 0 || response.Skipped != 2 {
+			t.Errorf("%v: Extract(skip) = %v, %v, want 2 skipped", name, response, err)
+		}
+		response, err = client.Extract(context.Background(), &pb.ExtractRequest{Path: "/p.zip", Dir: "/out", Conflict: pb.ExtractConflict_REPLACE})
+		if err != nil || response.Files != 2 {
+			t.Errorf("%v: Extract(replace) = %v, %v, want 2 files", name, response, err)
+		}
+	}
+}
+
+func TestExtractTarZst(t *testing.T) {
+	t.Parallel()
+	b := memory.New()
+	client := newTestClient(t, b)
+	buf := new(bytes.Buffer)
+	zst, err := zstd.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tar.NewWriter(zst)
+	w.WriteHeader(&tar.Header{Name: "a.txt", Size: 5, Mode: 0o644})
+	w.Write([]byte("hello"))
+	w.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	w.Close()
+	zst.Close()
+	if _, err := upload(client, buf.Bytes(), "path", "/p.tar.zst"); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := client.Extract(context.Background(), &pb.ExtractRequest{Path: "/p.tar.zst"})
+	if err != nil || response.Files != 1 || response.Skipped != 1 || response.Bytes != 5 {
+		t.Errorf("Extract = %v, %v, want 1 file and 1 skipped link", response, err)
+	}
+	if data, err := readFile(b, "/a.txt"); err != nil || string(data) != "hello" {
+		t.Errorf("a.txt = %q, %v", data, err)
+	}
+}
+
+func TestExtractErrors(t *testing.T) {
+	t.Parallel()
+	server := New(memory.New())
+	server.MaxExtractEntries = 2
+	server.MaxExtractSize = 1000
+	client := newTestServerClient(t, server)
+	archives := map[string][]byte{
+		"/slip.zip":    makeZip(t, "../evil.txt"),
+		"/entries.zip": makeZip(t, "a", "b", "c"),
+		"/size.zip":    makeZip(t, "a", strings.Repeat("b", 1000)),
+		// copied to temporary file by memory backend, long name makes it
+		// larger than limit though content fits
+		"/copy.zip": makeZip(t, strings.Repeat("c", 600)),
+		"/file.txt": []byte("not archive"),
+	}
+	for path, data := range archives {
+		if _, err := upload(client, data, "path", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		request *pb.ExtractRequest
+		code    codes.Code
+	}{
+		{&pb.ExtractRequest{Path: "/slip.zip"}, codes.InvalidArgument},
+		{&pb.ExtractRequest{Path: "/entries.zip", Dir: "/entries"}, codes.ResourceExhausted},
+		{&pb.ExtractRequest{Path: "/size.zip", Dir: "/size"}, codes.ResourceExhausted},
+		{&pb.ExtractRequest{Path: "/copy.zip", Dir: "/copy"}, codes.ResourceExhausted},
+		{&pb.ExtractRequest{Path: "/file.txt"}, codes.InvalidArgument},
+		{&pb.ExtractRequest{Path: "/file.txt", Format: pb.ArchiveFormat_TAR_GZ}, codes.InvalidArgument},
+		{&pb.ExtractRequest{Path: "/missing.zip"}, codes.NotFound},
+		{&pb.ExtractRequest{Path: "/slip.zip", Dir: "/file.txt"}, codes.AlreadyExists},
+	}
+	for _, test := range tests {
+		if _, err := client.Extract(context.Background(), test.request); status.Code(err) != test.code {
+			t.Errorf("Extract(%v) err = %v, want %v", test.request, err, test.code)
+		}
+	}
+	if exist, _ := backend.IsExist(server.Backend, "/evil.txt"); exist {
+		t.Errorf("entry of zip slip is extracted")
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	t.Parallel()
+	cache, err := thumbnail.NewCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(newTestBackend(t))
+	server.Thumbnails = cache
+	client := newTestServerClient(t, server)
+
+	img := new(bytes.Buffer)
+	if err := png.Encode(img, image.NewGray(image.Rect(0, 0, 100, 50))); err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := upload(client, img.Bytes(), "path", "/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		response, err := client.Thumbnail(context.Background(), &pb.ThumbnailRequest{Path: "/img.png", Size: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(response.Data))
+		if err != nil || response.MimeType != "image/jpeg" || response.Revision != uploaded.Revision || config.Width != 20 || config.Height != 10 {
+			t.Errorf("Thumbnail = %v %v %vx%v, %v, want image/jpeg %v 20x10", response.MimeType, response.Revision, config.Width, config.Height, err, uploaded.Revision)
+		}
+	}
+	if _, _, ok := cache.Get("/img.png", uploaded.Revision, 20); !ok {
+		t.Errorf("thumbnail isn't cached")
+	}
+
+	tests := []struct {
+		request *pb.ThumbnailRequest
+		code    codes.Code
+	}{
+		{&pb.ThumbnailRequest{Path: "/file1.txt", Size: 20}, codes.FailedPrecondition},
+		{&pb.ThumbnailRequest{Path: "/dir1", Size: 20}, codes.FailedPrecondition},
+		{&pb.ThumbnailRequest{Path: "/missing.png", Size: 20}, codes.NotFound},
+		{&pb.ThumbnailRequest{Path: "/img.png", Size: 0}, codes.InvalidArgument},
+		{&pb.ThumbnailRequest{Path: "/img.png", Size: thumbnail.MaxSize + 1}, codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		if _, err := client.Thumbnail(context.Background(), test.request); status.Code(err) != test.code {
+			t.Errorf("Thumbnail(%v) err = %v, want %v", test.request, err, test.code)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+	s := New(newTestBackend(t))
+	client := newTestServerClient(t, s)
+	if _, err := recvWatch(client, &pb.WatchRequest{Path: "/"}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("Watch without events err = %v, want Unimplemented", err)
+	}
+	s.Events = events.NewHub()
+	if _, err := recvWatch(client, &pb.WatchRequest{Path: "/missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Watch of missing path err = %v, want NotFound", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, &pb.WatchRequest{Path: "/dir1", Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Mkdir(ctx, &pb.MkdirRequest{Path: "/dir1/sub"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload(client, []byte("new"), "path", "/dir1/sub/new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload(client, []byte("changed"), "path", "/dir1/file3.txt", "mode", pb.WriteMode_OVERWRITE.String()); err != nil {
+		t.Fatal(err)
+	}
+	// not watched
+	if _, err := client.Remove(ctx, &pb.RemoveRequest{Path: "/file1.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Move(ctx, &pb.MoveRequest{Src: "/dir1/sub", Dst: "/moved"}); err != nil {
+		t.Fatal(err)
+	}
+	// failed copy changes nothing
+	if _, err := copyTree(client, &pb.CopyRequest{Src: "/file2.txt", Dst: "/dir1/file3.txt"}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("Copy over file err = %v, want AlreadyExists", err)
+	}
+	if _, err := client.RemoveAll(ctx, &pb.RemoveAllRequest{Path: "/dir1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"CREATE /dir1/sub dir",
+		"CREATE /dir1/sub/new.txt",
+		"MODIFY /dir1/file3.txt",
+		"MOVE /dir1/sub->/moved dir",
+		"REMOVE /dir1 dir",
+	}
+	for _, w := range want {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := event.Type.String() + " " + event.Path
+		if event.OldPath != "" {
+			got = event.Type.String() + " " + event.OldPath + "->" + event.Path
+		}
+		if event.Dir {
+			got += " dir"
+		}
+		if got != w {
+			t.Errorf("event = %v, want %v", got, w)
+		}
+	}
+}
+
+func TestListChanges(t *testing.T) {
+	t.Parallel()
+	s := New(newTestBackend(t))
+	client := newTestServerClient(t, s)
+	ctx := context.Background()
+	if _, err := client.ListChanges(ctx, &pb.ListChangesRequest{}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("ListChanges without journal err = %v, want Unimplemented", err)
+	}
+	j, err := journal.Open("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Journal = j
+
+	response, err := client.ListChanges(ctx, &pb.ListChangesRequest{})
+	if err != nil || len(response.Changes) != 0 || response.Cursor == "" {
+		t.Fatalf("ListChanges without cursor = %v, %v", response, err)
+	}
+	cursor := response.Cursor
+	if _, err := client.Mkdir(ctx, &pb.MkdirRequest{Path: "/new"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload(client, []byte("data"), "path", "/new/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Remove(ctx, &pb.RemoveRequest{Path: "/file1.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err = client.ListChanges(ctx, &pb.ListChangesRequest{Cursor: cursor, Limit: 2})
+	if err != nil || !response.HasMore || len(response.Changes) != 2 || response.Changes[1].Path != "/new/file.txt" {
+		t.Fatalf("ListChanges = %v, %v", response, err)
+	}
+	response, err = client.ListChanges(ctx, &pb.ListChangesRequest{Cursor: response.Cursor})
+	if err != nil || response.HasMore || len(response.Changes) != 1 || response.Changes[0].Type != pb.WatchEvent_REMOVE {
+		t.Fatalf("ListChanges after cursor = %v, %v", response, err)
+	}
+	response, err = client.ListChanges(ctx, &pb.ListChangesRequest{Cursor: cursor, Path: "/new"})
+	if err != nil || len(response.Changes) != 2 {
+		t.Errorf("ListChanges of /new = %v, %v", response, err)
+	}
+
+	// cursor of journal before restart
+	s.Journal, err = journal.Open("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = client.ListChanges(ctx, &pb.ListChangesRequest{Cursor: cursor})
+	if err != nil || !response.Reset_ || response.Cursor != s.Journal.Cursor() {
+		t.Errorf("ListChanges of expired cursor = %v, %v, want reset", response, err)
+	}
+	if _, err := client.ListChanges(ctx, &pb.ListChangesRequest{Cursor: "invalid"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ListChanges of invalid cursor err = %v, want InvalidArgument", err)
+	}
+}
+
+// first event or error of Watch
+func recvWatch(client pb.StorageServiceClient, request *pb.WatchRequest) (*pb.WatchEvent, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return stream.Recv()
+}
+
+func TestStat(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	fm := &filemanager.FileManager{Root: root}
+	if err := os.WriteFile(filepath.Join(root, "page.html"), []byte("<html><body>page</body></html>"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"link": "page.html", "dangling": "missing"} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := newTestClient(t, fm)
+
+	response, err := client.Stat(context.Background(), &pb.StatRequest{Path: "/page.html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Name != "page.html" || response.Size != 30 || response.Mode != 0640 || response.Dir || response.Symlink ||
+		response.MimeType != "text/html; charset=utf-8" || response.ModTime == 0 || response.Revision == "" {
+		t.Errorf("Stat(file) = %v", response)
+	}
+
+	response, err = client.Stat(context.Background(), &pb.StatRequest{Path: "/link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Symlink || response.Size != 30 {
+		t.Errorf("Stat(link) = %v", response)
+	}
+	response, err = client.Stat(context.Background(), &pb.StatRequest{Path: "/dangling"})
+	if err != nil || !response.Symlink {
+		t.Errorf("Stat(dangling) = %v, %v", response, err)
+	}
+
+	response, err = client.Stat(context.Background(), &pb.StatRequest{Path: "/"})
+	if err != nil || !response.Dir || response.MimeType != "" {
+		t.Errorf("Stat(root) = %v, %v", response, err)
+	}
+	_, err = client.Stat(context.Background(), &pb.StatRequest{Path: "/missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Stat(missing) err = %v, want %v", err, codes.NotFound)
+	}
+
+	// type of file that can't be read is guessed by extension
+	response, err = newTestClient(t, failingOpen{newTestBackend(t)}).Stat(context.Background(), &pb.StatRequest{Path: "/file1.txt"})
+	if err != nil || response.MimeType != "text/plain; charset=utf-8" {
+		t.Errorf("Stat(unreadable) = %v, %v", response, err)
+	}
+}
+
+// backend failing to open files
+type failingOpen struct {
+	backend.Backend
+}
+
+func (b failingOpen) Open(path string) (io.ReadCloser, error) {
+	return nil, os.ErrPermission
+}
+
+// client stream of Walk or Search
+type walkClient interface {
+	Recv() (*pb.WalkResponse, error)
+}
+
+// receive all entries of stream, return their paths without prefix
+func walkPaths(stream walkClient, prefix string) (string, error) {
+	paths := make([]string, 0)
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			return strings.Join(paths, " "), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		paths = append(paths, strings.TrimPrefix(response.Path, prefix))
+	}
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+	b := memory.New()
+	client := newTestClient(t, b)
+	for _, dir := range []string{"/w", "/w/b", "/w/b/d"} {
+		if err := b.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{"/w/a.txt", "/w/b/c.txt", "/w/b/d/e.txt", "/w/b.txt"} {
+		if _, err := upload(client, []byte(path), "path", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walk := func(request *pb.WalkRequest) (string, error) {
+		stream, err := client.Walk(context.Background(), request)
+		if err != nil {
+			return "", err
+		}
+		return walkPaths(stream, "/w/")
+	}
+
+	all := "a.txt b b/c.txt b/d b/d/e.txt b.txt"
+	tests := []struct {
+		request *pb.WalkRequest
+		want    string
+	}{
+		{&pb.WalkRequest{Path: "/w"}, all},
+		{&pb.WalkRequest{Path: "/w", MaxDepth: 1}, "a.txt b b.txt"},
+		{&pb.WalkRequest{Path: "/w", Include: []string{"*.txt"}}, "a.txt b/c.txt b/d/e.txt b.txt"},
+		{&pb.WalkRequest{Path: "/w", Exclude: []string{"d"}}, "a.txt b b/c.txt b.txt"},
+		{&pb.WalkRequest{Path: "/w", Exclude: []string{"b/*.txt"}}, "a.txt b b/d b/d/e.txt b.txt"},
+	}
+	for _, test := range tests {
+		got, err := walk(test.request)
+		if err != nil || got != test.want {
+			t.Errorf("Walk(%v) = %v, %v, want %v", test.request, got, err, test.want)
+		}
+	}
+
+	// continue after every entry
+	paths := strings.Split(all, " ")
+	for i, path := range paths {
+		got, err := walk(&pb.WalkRequest{Path: "/w", Cursor: "/w/" + path})
+		if want := strings.Join(paths[i+1:], " "); err != nil || got != want {
+			t.Errorf("Walk(cursor %v) = %v, %v, want %v", path, got, err, want)
+		}
+	}
+
+	for _, request := range []*pb.WalkRequest{{Path: "/w", Cursor: "/other"}, {Path: "/w", Include: []string{"["}}} {
+		_, err := walk(request)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Walk(%v) err = %v, want %v", request, err, codes.InvalidArgument)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+	b := memory.New()
+	client := newTestClient(t, b)
+	for _, dir := range []string{"/s", "/s/docs", "/s/Reports"} {
+		if err := b.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, size := range map[string]int{"/s/report-1.pdf": 10, "/s/docs/report-22.pdf": 100, "/s/docs/notes.txt": 5} {
+		if _, err := upload(client, bytes.Repeat([]byte("x"), size), "path", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		request *pb.SearchRequest
+		want    string
+	}{
+		{&pb.SearchRequest{Path: "/s", Glob: "report*"}, "Reports docs/report-22.pdf report-1.pdf"},
+		{&pb.SearchRequest{Path: "/s", Regexp: `^report-\d+\.pdf$`}, "docs/report-22.pdf report-1.pdf"},
+		{&pb.SearchRequest{Path: "/s", Type: pb.SearchRequest_DIR}, "Reports docs"},
+		{&pb.SearchRequest{Path: "/s", Type: pb.SearchRequest_FILE, MinSize: 6, MaxSize: 50}, "report-1.pdf"},
+		{&pb.SearchRequest{Path: "/s", Glob: "*.pdf", Limit: 1}, "docs/report-22.pdf"},
+		{&pb.SearchRequest{Path: "/s", ModifiedAfter: time.Now().Add(time.Hour).UnixMilli()}, ""},
+		{&pb.SearchRequest{Path: "/s", ModifiedBefore: time.Now().Add(time.Hour).UnixMilli(), Glob: "*.txt"}, "docs/notes.txt"},
+	}
+	for _, test := range tests {
+		stream, err := client.Search(context.Background(), test.request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := walkPaths(stream, "/s/")
+		if err != nil || got != test.want {
+			t.Errorf("Search(%v) = %v, %v, want %v", test.request, got, err, test.want)
+		}
+	}
+
+	for _, request := range []*pb.SearchRequest{{Path: "/s", Regexp: "("}, {Path: "/s", Glob: "["}, {Path: "/missing"}} {
+		stream, err := client.Search(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := walkPaths(stream, ""); err == nil {
+			t.Errorf("Search(%v) err = nil", request)
+		}
+	}
+}
+
+func TestSearchContent(t *testing.T) {
+	t.Parallel()
+	b := newTestBackend(t)
+	s := New(b)
+	client := newTestServerClient(t, s)
+	if _, err := client.SearchContent(context.Background(), &pb.SearchContentRequest{Query: "file", Path: "/"}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("SearchContent without index err = %v, want Unimplemented", err)
+	}
+	ix, err := index.Open(b, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ix.Close() })
+	s.Index = ix
+
+	if _, err := upload(client, []byte("first line\nsecond Line with Needle\n"), "path", "/dir1/notes.md"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Move(context.Background(), &pb.MoveRequest{Src: "/dir1", Dst: "/moved"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Remove(context.Background(), &pb.RemoveRequest{Path: "/file2.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	ix.Sync()
+
+	tests := []struct {
+		request *pb.SearchContentRequest
+		want    string
+	}{
+		{&pb.SearchContentRequest{Query: "needle LINE", Path: "/"}, "/moved/notes.md:2:second Line with Needle"},
+		{&pb.SearchContentRequest{Query: "name", Path: "/"}, "/dir2/file4.txt:1 /file1.txt:1 /moved/file3.txt:1"},
+		{&pb.SearchContentRequest{Query: "name", Path: "/moved"}, "/moved/file3.txt:1"},
+		{&pb.SearchContentRequest{Query: "name", Path: "/", Limit: 1}, "/dir2/file4.txt:1"},
+		{&pb.SearchContentRequest{Query: "file2", Path: "/"}, ""},
+	}
+	for _, test := range tests {
+		response, err := client.SearchContent(context.Background(), test.request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0)
+		for _, hit := range response.Hits {
+			if hit.Line == 2 {
+				got = append(got, fmt.Sprintf("%v:%v:%v", hit.Path, hit.Line, hit.Snippet))
+			} else {
+				got = append(got, fmt.Sprintf("%v:%v", hit.Path, hit.Line))
+			}
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("SearchContent(%v) = %v, want %v", test.request, got, test.want)
+		}
+	}
+
+	if _, err := client.SearchContent(context.Background(), &pb.SearchContentRequest{Query: " ", Path: "/"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SearchContent with empty query err = %v, want InvalidArgument", err)
+	}
+}
+
+func TestPathEscape(t *testing.T) {
+	t.Parallel()
+
+	// symlinks need real filesystem
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	if err := os.Mkdir(root, 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "secret.txt"), []byte("secret"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(base, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, &filemanager.FileManager{Root: root})
+
+	_, err := client.ReadDir(context.Background(), &pb.ReadDirRequest{Path: "/../"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ReadDir(..) err = %v, want %v", err, codes.InvalidArgument)
+	}
+	_, err = client.ReadDir(context.Background(), &pb.ReadDirRequest{Path: "/link"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ReadDir(link) err = %v, want %v", err, codes.PermissionDenied)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.TODO(), "path", "link/secret.txt")
+	stream, err := client.Download(ctx, &pb.DownloadRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Download(link/secret.txt) err = %v, want %v", err, codes.PermissionDenied)
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	t.Parallel()
+	client := newTestClient(t, &filemanager.FileManager{Root: t.TempDir()})
+
+	_, err := client.Mkdir(context.Background(), &pb.MkdirRequest{Path: "/.ns-upload-dir"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Mkdir(reserved name) err = %v, want %v", err, codes.InvalidArgument)
+	}
+	_, err = upload(client, []byte("data"), "path", "/.ns-upload-file")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Upload(reserved name) err = %v, want %v", err, codes.InvalidArgument)
+	}
 }
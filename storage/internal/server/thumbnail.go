@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+	"github.com/muskelo/ns_server/storage/internal/thumbnail"
+)
+
+func (s *Server) Thumbnail(ctx context.Context, request *pb.ThumbnailRequest) (*pb.ThumbnailResponse, error) {
+	if request.Path == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing path")
+	}
+	if request.Size < 1 || request.Size > thumbnail.MaxSize {
+		return nil, status.Errorf(codes.InvalidArgument, "size must be from 1 to %v", thumbnail.MaxSize)
+	}
+	info, exist, err := s.Backend.Stat(request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "file %v not found", request.Path)
+	}
+	if info.IsDir() {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v is a directory", request.Path)
+	}
+	revision := fileRevision(info)
+	response := &pb.ThumbnailResponse{Revision: revision, ModTime: info.ModTime().UnixMilli()}
+	size := int(request.Size)
+
+	var ok bool
+	response.Data, response.MimeType, ok = s.Thumbnails.Get(request.Path, revision, size)
+	if ok {
+		return response, nil
+	}
+	file, err := s.Backend.Open(request.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	response.Data, response.MimeType, err = thumbnail.Make(file, size)
+	file.Close()
+	switch {
+	case errors.Is(err, thumbnail.ErrFormat):
+		return nil, status.Errorf(codes.FailedPrecondition, "%v is not supported image", request.Path)
+	case errors.Is(err, thumbnail.ErrTooLarge):
+		return nil, status.Errorf(codes.ResourceExhausted, "image %v is too large", request.Path)
+	case err != nil:
+		return nil, statusError(err)
+	}
+
+	// don't cache content of new revision under old one
+	info, exist, err = s.Backend.Stat(request.Path)
+	if err == nil && exist && fileRevision(info) == revision {
+		if err := s.Thumbnails.Put(request.Path, revision, size, response.Data, response.MimeType); err != nil {
+			log.Printf("thumbnail cache: %v", err)
+		}
+	}
+	return response, nil
+}
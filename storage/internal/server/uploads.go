@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+	"github.com/muskelo/ns_server/storage/internal/backend"
+	"github.com/muskelo/ns_server/storage/internal/uploads"
+)
+
+// CreateUpload stages empty resumable upload, empty file is written at once
+func (s *Server) CreateUpload(ctx context.Context, request *pb.CreateUploadRequest) (*pb.UploadStatusResponse, error) {
+	if s.Uploads == nil {
+		return nil, status.Error(codes.Unimplemented, "resumable uploads are disabled")
+	}
+	if _, err := backend.Split("upload", request.Path); err != nil {
+		return nil, statusError(err)
+	}
+	if request.Size < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid size")
+	}
+	if request.Mode == pb.WriteMode_OVERWRITE_IF_UNCHANGED && request.Revision == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing revision")
+	}
+	digest, err := checkDigest(request.Sha256)
+	if err != nil {
+		return nil, err
+	}
+	// fail early, before receiving data
+	if err := s.checkWriteMode(request.Path, request.Mode, request.Revision); err != nil {
+		return nil, err
+	}
+
+	info, err := s.Uploads.Create(uploads.Info{
+		Path:     request.Path,
+		Mode:     int32(request.Mode),
+		Revision: request.Revision,
+		Sha256:   digest,
+		Size:     request.Size,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info.Size > 0 {
+		return uploadStatus(info), nil
+	}
+	release, err := s.Uploads.Acquire(info.ID)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	defer release()
+	return s.commitUpload(info)
+}
+
+func (s *Server) UploadStatus(ctx context.Context, request *pb.UploadStatusRequest) (*pb.UploadStatusResponse, error) {
+	if s.Uploads == nil {
+		return nil, status.Error(codes.Unimplemented, "resumable uploads are disabled")
+	}
+	info, err := s.Uploads.Get(request.Id)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	return uploadStatus(info), nil
+}
+
+// AppendUpload writes received data at offset of upload and writes file when
+// upload is complete
+func (s *Server) AppendUpload(stream pb.StorageService_AppendUploadServer) error {
+	if s.Uploads == nil {
+		return status.Error(codes.Unimplemented, "resumable uploads are disabled")
+	}
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	var id, offset string
+	if v := md.Get("id"); len(v) > 0 {
+		id = v[0]
+	}
+	if v := md.Get("offset"); len(v) > 0 {
+		offset = v[0]
+	}
+	n, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "invalid offset")
+	}
+
+	release, err := s.Uploads.Acquire(id)
+	if err != nil {
+		return uploadError(err)
+	}
+	defer release()
+	streamReader := new(pb.StreamReader)
+	streamReader.StorageService_AppendUploadServer(stream)
+	info, err := s.Uploads.Append(id, n, streamReader)
+	if err != nil {
+		return uploadError(err)
+	}
+	if info.Offset < info.Size {
+		return stream.SendAndClose(uploadStatus(info))
+	}
+	response, err := s.commitUpload(info)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(response)
+}
+
+func (s *Server) AbortUpload(ctx context.Context, request *pb.AbortUploadRequest) (*pb.AbortUploadResponse, error) {
+	if s.Uploads == nil {
+		return nil, status.Error(codes.Unimplemented, "resumable uploads are disabled")
+	}
+	release, err := s.Uploads.Acquire(request.Id)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	defer release()
+	if err := s.Uploads.Remove(request.Id); err != nil {
+		return nil, uploadError(err)
+	}
+	return &pb.AbortUploadResponse{}, nil
+}
+
+// write data of complete upload to its path and remove it, caller must hold
+// upload. Upload which can't be written is removed too, retry can't fix it.
+func (s *Server) commitUpload(info uploads.Info) (*pb.UploadStatusResponse, error) {
+	data, err := s.Uploads.Open(info.ID)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	result, err := s.write(info.Path, pb.WriteMode(info.Mode), info.Revision, info.Sha256, data)
+	data.Close()
+	if removeErr := s.Uploads.Remove(info.ID); err == nil && removeErr != nil {
+		return nil, removeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	response := uploadStatus(info)
+	response.Result = result
+	return response, nil
+}
+
+func uploadStatus(info uploads.Info) *pb.UploadStatusResponse {
+	return &pb.UploadStatusResponse{
+		Id:      info.ID,
+		Path:    info.Path,
+		Size:    info.Size,
+		Offset:  info.Offset,
+		Expires: info.Expires.UnixMilli(),
+	}
+}
+
+// convert uploads errors to grpc status
+func uploadError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, "upload not found")
+	case errors.Is(err, uploads.ErrOffset):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, uploads.ErrBusy):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, uploads.ErrTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return err
+	}
+}
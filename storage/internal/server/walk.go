@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+// errLimit stops walk when enough entries are sent
+var errLimit = errors.New("limit reached")
+
+// server stream of Walk and Search
+type walkStream interface {
+	Send(*pb.WalkResponse) error
+	Context() context.Context
+}
+
+type walker struct {
+	s      *Server
+	stream walkStream
+	// 0 is unlimited
+	maxDepth int
+	exclude  []string
+	// names of cursor relative to walked directory
+	cursor []string
+	// filters of sent entries, nil matches all
+	match     func(entry walkEntry, relPath string) bool
+	matchInfo func(info fs.FileInfo) bool
+	// max number of sent entries, 0 is unlimited
+	limit int
+	sent  int
+}
+
+type walkEntry struct {
+	name string
+	path string
+	dir  bool
+}
+
+// Walk sends entries of subtree depth-first. Children are walked in byte
+// order of names, so order of entries is order of their names lists and walk
+// can continue after cursor without reading skipped subtrees.
+func (s *Server) Walk(request *pb.WalkRequest, stream pb.StorageService_WalkServer) error {
+	names, err := s.checkWalkRoot(request.Path)
+	if err != nil {
+		return err
+	}
+	if request.MaxDepth < 0 {
+		return status.Error(codes.InvalidArgument, "invalid max depth")
+	}
+	for _, patterns := range [][]string{request.Include, request.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return status.Errorf(codes.InvalidArgument, "invalid pattern %q", pattern)
+			}
+		}
+	}
+
+	w := &walker{s: s, stream: stream, maxDepth: int(request.MaxDepth), exclude: request.Exclude}
+	if len(request.Include) > 0 {
+		w.match = func(entry walkEntry, relPath string) bool {
+			return matchAny(request.Include, entry.name, relPath)
+		}
+	}
+	if request.Cursor != "" {
+		cursor, err := backend.Split("walk", request.Cursor)
+		if err != nil {
+			return statusError(err)
+		}
+		if len(cursor) <= len(names) || compareNames(cursor[:len(names)], names) != 0 {
+			return status.Error(codes.InvalidArgument, "cursor is outside of path")
+		}
+		w.cursor = cursor[len(names):]
+	}
+	return w.run(request.Path)
+}
+
+// validate walked directory and split it into names
+func (s *Server) checkWalkRoot(dir string) ([]string, error) {
+	names, err := backend.Split("walk", dir)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	exist, err := backend.IsDirExist(s.Backend, dir)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Directory %v not exist", dir)
+	}
+	return names, nil
+}
+
+func (w *walker) run(dir string) error {
+	err := w.walk(dir, nil)
+	if errors.Is(err, errLimit) {
+		return nil
+	}
+	if err != nil && w.stream.Context().Err() != nil {
+		return status.FromContextError(w.stream.Context().Err()).Err()
+	}
+	return err
+}
+
+// walk children of dir, rel is names of dir relative to walked directory
+func (w *walker) walk(dir string, rel []string) error {
+	files, dirs, err := w.s.Backend.ReadDir(dir)
+	if err != nil {
+		return statusError(err)
+	}
+	entries := make([]walkEntry, 0, len(files)+len(dirs))
+	for _, d := range dirs {
+		entries = append(entries, walkEntry{name: d.Name, path: d.Path, dir: true})
+	}
+	for _, f := range files {
+		entries = append(entries, walkEntry{name: f.Name, path: f.Path})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	for _, entry := range entries {
+		if err := w.stream.Context().Err(); err != nil {
+			return err
+		}
+		names := append(rel[:len(rel):len(rel)], entry.name)
+		relPath := strings.Join(names, "/")
+		if matchAny(w.exclude, entry.name, relPath) {
+			continue
+		}
+
+		// cursor and its ancestors are sent already, but can have unsent children
+		ancestor := len(names) < len(w.cursor) && compareNames(names, w.cursor[:len(names)]) == 0
+		switch cmp := compareNames(names, w.cursor); {
+		case cmp > 0:
+			if err := w.send(entry, len(names), relPath); err != nil {
+				return err
+			}
+		case cmp < 0 && !ancestor:
+			continue
+		}
+
+		if entry.dir && (w.maxDepth == 0 || len(names) < w.maxDepth) {
+			if err := w.walk(entry.path, names); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *walker) send(entry walkEntry, depth int, relPath string) error {
+	if w.match != nil && !w.match(entry, relPath) {
+		return nil
+	}
+	info, exist, err := w.s.Backend.Stat(entry.path)
+	if err != nil {
+		return statusError(err)
+	}
+	if !exist {
+		// removed while walking
+		return nil
+	}
+	if w.matchInfo != nil && !w.matchInfo(info) {
+		return nil
+	}
+
+	err = w.stream.Send(&pb.WalkResponse{
+		Name:    entry.name,
+		Path:    entry.path,
+		Dir:     entry.dir,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixMilli(),
+		Depth:   int32(depth),
+	})
+	if err != nil {
+		return err
+	}
+	w.sent++
+	if w.limit > 0 && w.sent >= w.limit {
+		return errLimit
+	}
+	return nil
+}
+
+// match name or relative path against patterns, see WalkRequest
+func matchAny(patterns []string, name, relPath string) bool {
+	for _, pattern := range patterns {
+		subject := name
+		if strings.Contains(pattern, "/") {
+			subject = relPath
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compare names lists lexicographically, prefix goes first
+func compareNames(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/muskelo/ns_server/protos/storage"
+	"github.com/muskelo/ns_server/storage/internal/backend"
+)
+
+var watchEventTypes = map[backend.ChangeOp]pb.WatchEvent_Type{
+	backend.OpCreate: pb.WatchEvent_CREATE,
+	backend.OpRemove: pb.WatchEvent_REMOVE,
+	backend.OpMove:   pb.WatchEvent_MOVE,
+	backend.OpModify: pb.WatchEvent_MODIFY,
+}
+
+// changed tells watchers and journal about change made by handler
+func (s *Server) changed(change backend.Change) {
+	s.Events.Publish(change)
+	s.Journal.Append(change)
+}
+
+// ExternalChange takes change noticed by backend, echoes of changes made by
+// handlers are dropped. It needs Events to tell them apart, so handlers
+// register their changes with events.Expect before making them.
+func (s *Server) ExternalChange(change backend.Change) {
+	if change.Op == backend.OpLost {
+		// watchers and sync clients must list everything again
+		log.Printf("changes under %v were lost", change.Path)
+		s.Events.Overflow()
+		if s.Journal != nil {
+			if err := s.Journal.Reset(); err != nil {
+				log.Printf("journal: reset: %v", err)
+			}
+		}
+		return
+	}
+	if s.Events.PublishExternal(change) {
+		s.Journal.Append(change)
+	}
+}
+
+// event of change made at unix milliseconds
+func newWatchEvent(change backend.Change, at int64) *pb.WatchEvent {
+	return &pb.WatchEvent{
+		Type:    watchEventTypes[change.Op],
+		Path:    change.Path,
+		OldPath: change.OldPath,
+		Dir:     change.Dir,
+		Time:    at,
+	}
+}
+
+// Watch sends changes until client cancels stream
+func (s *Server) Watch(request *pb.WatchRequest, stream pb.StorageService_WatchServer) error {
+	if s.Events == nil {
+		return status.Error(codes.Unimplemented, "events are disabled")
+	}
+	if _, err := backend.Split("watch", request.Path); err != nil {
+		return statusError(err)
+	}
+	exist, err := backend.IsExist(s.Backend, request.Path)
+	if err != nil {
+		return statusError(err)
+	}
+	if !exist {
+		return status.Errorf(codes.NotFound, "File or Directory %v not found", request.Path)
+	}
+
+	w := s.Events.Watch(request.Path, request.Recursive)
+	defer w.Close()
+	// tell client that changes from now on are sent
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case change, ok := <-w.Events:
+			if !ok && w.Lost() {
+				return status.Error(codes.ResourceExhausted, "too many changes, some were lost")
+			}
+			if !ok {
+				return status.Error(codes.Unavailable, "server is shutting down")
+			}
+			if err := stream.Send(newWatchEvent(change, time.Now().UnixMilli())); err != nil {
+				return err
+			}
+		}
+	}
+}
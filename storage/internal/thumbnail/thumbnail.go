@@ -0,0 +1,190 @@
+// Package thumbnail makes resized previews of images and caches them on
+// local disk. Cache entry is keyed by path and size and keeps revision of
+// file it is made from, so changed file misses cache and replaces entry.
+package thumbnail
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// max width and height of thumbnail
+	MaxSize = 512
+	// larger files aren't read
+	MaxFileSize = 64 << 20
+	// larger images aren't decoded, decoded image takes 4 bytes per pixel
+	MaxPixels = 32 << 20
+)
+
+var (
+	// file isn't JPEG, PNG, GIF or WebP image
+	ErrFormat = errors.New("unsupported image format")
+	// file or image exceeds MaxFileSize or MaxPixels
+	ErrTooLarge = errors.New("image is too large")
+)
+
+// Make decodes image and scales it to fit size x size, smaller images keep
+// their size. Opaque thumbnail is JPEG, other is PNG.
+func Make(r io.Reader, size int) (data []byte, mimeType string, err error) {
+	content, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(content) > MaxFileSize {
+		return nil, "", ErrTooLarge
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", ErrFormat
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", ErrFormat
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width > height {
+			width, height = size, height*size/width
+		} else {
+			width, height = width*size/height, size
+		}
+		if width == 0 {
+			width = 1
+		}
+		if height == 0 {
+			height = 1
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	buf := new(bytes.Buffer)
+	mimeType = "image/png"
+	if dst.Opaque() {
+		mimeType = "image/jpeg"
+		err = jpeg.Encode(buf, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(buf, dst)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), mimeType, nil
+}
+
+// Cache stores thumbnails in dir, nil Cache stores nothing
+type Cache struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewCache creates cache in dir, entries not used for ttl are removed by
+// Sweep
+func NewCache(dir string, ttl time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir, ttl: ttl}, nil
+}
+
+// Get returns thumbnail of revision of file, ok is false on miss
+func (c *Cache) Get(path, revision string, size int) (data []byte, mimeType string, ok bool) {
+	if c == nil {
+		return nil, "", false
+	}
+	name := c.file(path, size)
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, "", false
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, "", false
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 2 || fields[0] != revision {
+		return nil, "", false
+	}
+	data, err = io.ReadAll(r)
+	if err != nil {
+		return nil, "", false
+	}
+	// mark as used for Sweep
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	return data, fields[1], true
+}
+
+// Put stores thumbnail, it replaces entry of previous revision
+func (c *Cache) Put(path, revision string, size int, data []byte, mimeType string) error {
+	if c == nil {
+		return nil
+	}
+	tmp, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(revision + " " + mimeType + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file(path, size))
+}
+
+// Sweep removes entries not used for ttl, entries of removed files are
+// removed only by it
+func (c *Cache) Sweep() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		stat, err := entry.Info()
+		if err != nil || time.Since(stat.ModTime()) < c.ttl {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, entry.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// hash keeps names short and inside dir whatever path is
+func (c *Cache) file(path string, size int) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+"-"+strconv.Itoa(size))
+}
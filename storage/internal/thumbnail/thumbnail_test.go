@@ -0,0 +1,96 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMake(t *testing.T) {
+	opaque := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 100; y++ {
+			opaque.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	transparent := image.NewNRGBA(image.Rect(0, 0, 10, 20))
+
+	tests := []struct {
+		img      image.Image
+		size     int
+		mimeType string
+		width    int
+		height   int
+	}{
+		{opaque, 100, "image/jpeg", 100, 25},
+		{opaque, 1000, "image/jpeg", 400, 100},
+		{transparent, 10, "image/png", 5, 10},
+	}
+	for _, test := range tests {
+		data, mimeType, err := Make(bytes.NewReader(encodePNG(t, test.img)), test.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mimeType != test.mimeType || config.Width != test.width || config.Height != test.height {
+			t.Errorf("Make(%v, %v) = %v %vx%v, want %v %vx%v", test.img.Bounds(), test.size, mimeType, config.Width, config.Height, test.mimeType, test.width, test.height)
+		}
+	}
+
+	if _, _, err := Make(strings.NewReader("not image"), 100); !errors.Is(err, ErrFormat) {
+		t.Errorf("Make(text) err = %v, want %v", err, ErrFormat)
+	}
+	huge := encodePNG(t, image.NewGray(image.Rect(0, 0, 1<<13, 1<<13)))
+	if _, _, err := Make(bytes.NewReader(huge), 100); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Make(huge) err = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestCache(t *testing.T) {
+	c, err := NewCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := c.Get("/a.png", "r1", 64); ok {
+		t.Errorf("Get(empty cache) hit")
+	}
+	if err := c.Put("/a.png", "r1", 64, []byte("data"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if data, mimeType, ok := c.Get("/a.png", "r1", 64); !ok || string(data) != "data" || mimeType != "image/png" {
+		t.Errorf("Get = %q, %v, %v, want data, image/png", data, mimeType, ok)
+	}
+	for _, miss := range []struct {
+		path, revision string
+		size           int
+	}{{"/a.png", "r2", 64}, {"/a.png", "r1", 128}, {"/b.png", "r1", 64}} {
+		if _, _, ok := c.Get(miss.path, miss.revision, miss.size); ok {
+			t.Errorf("Get(%v) hit", miss)
+		}
+	}
+
+	var nilCache *Cache
+	if err := nilCache.Put("/a.png", "r1", 64, []byte("data"), "image/png"); err != nil {
+		t.Errorf("nil Put err = %v", err)
+	}
+	if _, _, ok := nilCache.Get("/a.png", "r1", 64); ok {
+		t.Errorf("nil Get hit")
+	}
+}
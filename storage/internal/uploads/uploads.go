@@ -0,0 +1,227 @@
+// Package uploads stages resumable uploads on local disk until all their
+// data is received. Upload is "<id>.json" with its Info and "<id>.data" with
+// received data, so offset survives restart of the storage node.
+package uploads
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// offset of append differs from received size
+	ErrOffset = errors.New("upload offset mismatch")
+	// another append of upload is in progress
+	ErrBusy = errors.New("upload is busy")
+	// append has more data than declared size
+	ErrTooLarge = errors.New("upload exceeds its size")
+)
+
+// Info describes upload, fields after Sha256 are set by Store
+type Info struct {
+	// destination of file and how it is written, see Server.Upload
+	Path     string
+	Mode     int32
+	Revision string
+	Sha256   string
+	Size     int64
+
+	ID string `json:"-"`
+	// size of received data
+	Offset int64 `json:"-"`
+	// upload is removed after it, extended by every append
+	Expires time.Time `json:"-"`
+}
+
+type Store struct {
+	dir string
+	ttl time.Duration
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// New creates store in dir, uploads without appends for ttl expire
+func New(dir string, ttl time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, ttl: ttl, busy: make(map[string]bool)}, nil
+}
+
+// Create stages empty upload
+func (s *Store) Create(info Info) (Info, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Info{}, err
+	}
+	info.ID = hex.EncodeToString(id)
+
+	data, err := os.OpenFile(s.file(info.ID, ".data"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := data.Close(); err != nil {
+		return Info{}, err
+	}
+	content, err := json.Marshal(info)
+	if err != nil {
+		return Info{}, err
+	}
+	// info is written last, upload without it is garbage of failed create
+	tmp := s.file(info.ID, ".json.tmp")
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return Info{}, err
+	}
+	if err := os.Rename(tmp, s.file(info.ID, ".json")); err != nil {
+		return Info{}, err
+	}
+	return s.Get(info.ID)
+}
+
+// Get returns fs.ErrNotExist for unknown and expired uploads
+func (s *Store) Get(id string) (Info, error) {
+	if !validID(id) {
+		return Info{}, fs.ErrNotExist
+	}
+	content, err := os.ReadFile(s.file(id, ".json"))
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{}
+	if err := json.Unmarshal(content, &info); err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(s.file(id, ".data"))
+	if err != nil {
+		return Info{}, err
+	}
+	info.ID = id
+	info.Offset = stat.Size()
+	info.Expires = stat.ModTime().Add(s.ttl)
+	if time.Now().After(info.Expires) {
+		return Info{}, fs.ErrNotExist
+	}
+	return info, nil
+}
+
+// Acquire locks upload for append and commit, it fails with ErrBusy
+// instead of waiting
+func (s *Store) Acquire(id string) (release func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[id] {
+		return nil, ErrBusy
+	}
+	s.busy[id] = true
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.busy, id)
+	}, nil
+}
+
+// Append writes data of r at offset, caller must hold upload. Data received
+// before error of r is kept, returned info has new offset.
+func (s *Store) Append(id string, offset int64, r io.Reader) (Info, error) {
+	info, err := s.Get(id)
+	if err != nil {
+		return Info{}, err
+	}
+	if offset != info.Offset {
+		return info, ErrOffset
+	}
+
+	data, err := os.OpenFile(s.file(id, ".data"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return info, err
+	}
+	rest := info.Size - info.Offset
+	n, err := io.Copy(data, io.LimitReader(r, rest+1))
+	if n > rest {
+		n, err = rest, ErrTooLarge
+		if truncErr := data.Truncate(info.Size); truncErr != nil {
+			err = truncErr
+		}
+	}
+	if syncErr := data.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := data.Close(); err == nil {
+		err = closeErr
+	}
+	info.Offset += n
+	info.Expires = time.Now().Add(s.ttl)
+	return info, err
+}
+
+// Open reads received data
+func (s *Store) Open(id string) (io.ReadCloser, error) {
+	if !validID(id) {
+		return nil, fs.ErrNotExist
+	}
+	return os.Open(s.file(id, ".data"))
+}
+
+// Remove deletes upload, unknown upload is fs.ErrNotExist
+func (s *Store) Remove(id string) error {
+	if !validID(id) {
+		return fs.ErrNotExist
+	}
+	err := os.Remove(s.file(id, ".json"))
+	if dataErr := os.Remove(s.file(id, ".data")); err == nil {
+		err = dataErr
+	}
+	return err
+}
+
+// Sweep removes expired uploads and garbage of failed creates
+func (s *Store) Sweep() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		id, ext, _ := strings.Cut(entry.Name(), ".")
+		if ext != "data" || !validID(id) {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil || time.Since(stat.ModTime()) < s.ttl {
+			continue
+		}
+		s.mu.Lock()
+		busy := s.busy[id]
+		s.mu.Unlock()
+		if busy {
+			continue
+		}
+		log.Printf("uploads: remove expired %v", id)
+		os.Remove(s.file(id, ".json"))
+		os.Remove(s.file(id, ".json.tmp"))
+		if err := os.Remove(s.file(id, ".data")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) file(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
+
+// id is generated hex, anything else could escape dir
+func validID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == 16
+}
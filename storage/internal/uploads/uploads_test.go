@@ -0,0 +1,103 @@
+package uploads
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s, err := New(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Create(Info{Path: "/a.txt", Size: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Offset != 0 || info.Path != "/a.txt" || info.Size != 6 {
+		t.Errorf("Create = %+v", info)
+	}
+
+	if info, err = s.Append(info.ID, 0, strings.NewReader("abc")); err != nil || info.Offset != 3 {
+		t.Fatalf("Append = %+v, %v, want offset 3", info, err)
+	}
+	if _, err := s.Append(info.ID, 0, strings.NewReader("abc")); !errors.Is(err, ErrOffset) {
+		t.Errorf("Append(wrong offset) err = %v, want %v", err, ErrOffset)
+	}
+	if info, err = s.Append(info.ID, 3, strings.NewReader("defgh")); !errors.Is(err, ErrTooLarge) || info.Offset != 6 {
+		t.Errorf("Append(too large) = %+v, %v, want offset 6, %v", info, err, ErrTooLarge)
+	}
+	if info, err = s.Get(info.ID); err != nil || info.Offset != 6 {
+		t.Errorf("Get = %+v, %v, want offset 6", info, err)
+	}
+	data, err := s.Open(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(data)
+	data.Close()
+	if err != nil || string(content) != "abcdef" {
+		t.Errorf("content = %q, %v, want %q", content, err, "abcdef")
+	}
+
+	release, err := s.Acquire(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Acquire(info.ID); !errors.Is(err, ErrBusy) {
+		t.Errorf("Acquire(busy) err = %v, want %v", err, ErrBusy)
+	}
+	release()
+	if err := s.Remove(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{info.ID, "../x", ""} {
+		if _, err := s.Get(id); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Get(%q) err = %v, want %v", id, err, fs.ErrNotExist)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := s.Create(Info{Path: "/a.txt", Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := s.Create(Info{Path: "/b.txt", Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, expired.ID+".data"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(expired.ID); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get(expired) err = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	if err := s.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := active.ID + ".data " + active.ID + ".json"; strings.Join(names, " ") != want {
+		t.Errorf("files after Sweep = %v, want %v", names, want)
+	}
+}